@@ -8,7 +8,7 @@ import (
 
 //Should these be included in the new "difficultyParmaters"?
 const BASElINE_DIFFICULTY = 1.0
-const STARTING_BLOCKS = 3000
+const defaultStartingBlocks = 3000
 
 //Block holds information on a block: its height, difficulty and timestamp
 type Block struct {
@@ -16,6 +16,14 @@ type Block struct {
 	difficulty float64
 	timestamp  int
 	isHonest   bool
+	id         int  //Index of the block in the block tree
+	parent     int  //id of the parent block, -1 for genesis
+	orphaned   bool //Only meaningful for the copy held in the block tree
+	fees       float64
+	backlog    float64 //Fees left in the mempool after this block
+	minedAt    int     //Real time the block was found, which may differ from its timestamp
+	uncles     []int   //ids of the orphaned blocks this block references as uncles
+	miner      int     //Index of the miner in a multi-miner simulation
 }
 
 type byTimestamp []Block
@@ -94,18 +102,28 @@ type Blockchain struct {
 	//diffAlgo              DifficultyAlgorithm
 	diffAlgo          Difficulty
 	expectedBlockTime int
+	tree              []Block //Every block ever mined, indexed by id
+	reorgDepths       []int   //Number of main chain blocks orphaned by each reorg
+	rewards           RewardModel
+	realTime          int       //Current real time of the simulation
+	privateUndercut   float64   //Fraction of available fees the private branch leaves in the mempool
+	uncleCandidates   []int     //ids of orphaned blocks recent enough to be included as uncles
+	clockOffsets      []float64 //Clock offset of every honest miner, empty for honest timestamps at the real time
+	startingBlocks    int       //Number of warm-up blocks before the simulated ones, set by -warmup
 }
 
-//Init initializes a blockchain with 150 blocks all with normal difficulty and time.
+//Init initializes a blockchain with the warm-up blocks.
 func (blockchain *Blockchain) Init() {
 	blockchain.height = -1 //Set to -1 since we are about to add genesis (0)
-	for i := 0; i < STARTING_BLOCKS; i++ {
-		blockchain.pushToChain(Block{
-			i, BASElINE_DIFFICULTY, i * blockchain.expectedBlockTime, true})
+	blockchain.startingBlocks = warmUp.Blocks
+	for _, block := range warmUp.blocks(blockchain.expectedBlockTime) {
+		blockchain.pushToChain(blockchain.record(block))
 	}
+	blockchain.realTime = blockchain.time
+	blockchain.clockOffsets = clockModel.offsets()
 	blockchain.forkHeight = 0
-	blockchain.nextDifficulty = BASElINE_DIFFICULTY
-	blockchain.nextPrivateDifficulty = BASElINE_DIFFICULTY
+	blockchain.nextDifficulty = blockchain.chain[blockchain.height].difficulty
+	blockchain.nextPrivateDifficulty = blockchain.nextDifficulty
 	//blockchain.chainType = unassigned
 }
 
@@ -119,6 +137,9 @@ func (blockchain *Blockchain) Reset() {
 	blockchain.chain = nil
 	blockchain.privateBranch = nil
 	blockchain.forkHistory = nil
+	blockchain.tree = nil
+	blockchain.reorgDepths = nil
+	blockchain.uncleCandidates = nil
 	blockchain.privateTime = 0
 	blockchain.nextDifficulty = 0.0
 	blockchain.nextPrivateDifficulty = 0.0
@@ -128,7 +149,7 @@ func (blockchain *Blockchain) Reset() {
 func (blockchain *Blockchain) stats() (sm, total int, winRatio float64) {
 	hm := 0
 	sm = 0
-	for _, block := range blockchain.chain[STARTING_BLOCKS:] {
+	for _, block := range blockchain.chain[blockchain.startingBlocks:] {
 		if block.isHonest {
 			hm++
 		} else {
@@ -141,6 +162,45 @@ func (blockchain *Blockchain) stats() (sm, total int, winRatio float64) {
 	return
 }
 
+//TreeStats holds the orphan counts of the block tree for the simulated (non starting) blocks
+type TreeStats struct {
+	honestMined      int
+	honestOrphaned   int
+	selfishMined     int
+	selfishOrphaned  int
+	honestWork       float64
+	wastedHonestWork float64
+}
+
+//treeStats walks the block tree and counts mined and orphaned blocks by miner.
+func (blockchain *Blockchain) treeStats() (ts TreeStats) {
+	for _, block := range blockchain.tree[blockchain.startingBlocks:] {
+		if block.isHonest {
+			ts.honestMined++
+			ts.honestWork += block.difficulty
+			if block.orphaned {
+				ts.honestOrphaned++
+				ts.wastedHonestWork += block.difficulty
+			}
+		} else {
+			ts.selfishMined++
+			if block.orphaned {
+				ts.selfishOrphaned++
+			}
+		}
+	}
+	return
+}
+
+//reorgDepthHistogram returns the number of reorgs for each reorg depth.
+func (blockchain *Blockchain) reorgDepthHistogram() map[int]int {
+	hist := make(map[int]int)
+	for _, depth := range blockchain.reorgDepths {
+		hist[depth]++
+	}
+	return hist
+}
+
 //printStats will print statistics about the blockchain.
 func (blockchain *Blockchain) printStats() {
 	fmt.Printf("Height: %d\n", blockchain.height)
@@ -148,6 +208,63 @@ func (blockchain *Blockchain) printStats() {
 	fmt.Printf("Private length: %d\tFork height: %d\n", len(blockchain.privateBranch), blockchain.forkHeight)
 }
 
+//record adds a newly mined block to the block tree and returns it with its id set.
+func (blockchain *Blockchain) record(block Block) Block {
+	block.id = len(blockchain.tree)
+	blockchain.tree = append(blockchain.tree, block)
+	return block
+}
+
+//orphan flags the given block as orphaned in the block tree.
+func (blockchain *Blockchain) orphan(block Block) {
+	blockchain.tree[block.id].orphaned = true
+	if blockchain.rewards.MaxUncles > 0 {
+		blockchain.uncleCandidates = append(blockchain.uncleCandidates, block.id)
+	}
+}
+
+//selectUncles returns the orphaned blocks a new block on the public chain or private branch can reference as uncles.
+//An uncle's parent must be an ancestor of the new block at most MaxUncleDepth blocks back, and
+//it must not already be referenced by another block of the chain.
+func (blockchain *Blockchain) selectUncles(isPrivate bool) (uncles []int) {
+	maxUncles, maxDepth := blockchain.rewards.MaxUncles, blockchain.rewards.MaxUncleDepth
+	if maxUncles == 0 || len(blockchain.uncleCandidates) == 0 {
+		return nil
+	}
+	var view []Block
+	if isPrivate {
+		view = blockchain.getPrivateView()
+	} else {
+		view = blockchain.chain
+	}
+	newHeight := len(view)
+
+	included := make(map[int]bool)
+	for _, block := range view[newHeight-maxDepth:] {
+		for _, id := range block.uncles {
+			included[id] = true
+		}
+	}
+
+	var recent []int
+	for _, id := range blockchain.uncleCandidates {
+		uncle := blockchain.tree[id]
+		if newHeight-uncle.height > maxDepth {
+			continue
+		}
+		recent = append(recent, id)
+		if len(uncles) < maxUncles && !included[id] && uncle.height < newHeight &&
+			view[uncle.height-1].id == uncle.parent && view[uncle.height].id != id {
+			uncles = append(uncles, id)
+		}
+	}
+	//Candidates too old for the public chain are too old for any private branch as well
+	if !isPrivate {
+		blockchain.uncleCandidates = recent
+	}
+	return
+}
+
 //pushToChain will push the given block to the public blockchain.
 func (blockchain *Blockchain) pushToChain(block Block) {
 	blockchain.chain = append(blockchain.chain, block)
@@ -195,10 +312,10 @@ func (blockchain *Blockchain) popFromPrivateChainBottom() Block {
 	return removedBlock
 }
 
-//clearPrivateBranch will remove all private branch blocks
+//clearPrivateBranch will remove all private branch blocks, marking them as orphaned in the block tree
 func (blockchain *Blockchain) clearPrivateBrach() {
 	for len(blockchain.privateBranch) > 0 {
-		blockchain.popFromPrivateChain()
+		blockchain.orphan(blockchain.popFromPrivateChain())
 	}
 }
 
@@ -220,6 +337,34 @@ func (blockchain Blockchain) getPostForkWork() (mainWork, privWork float64) {
 	return
 }
 
+//getPostForkSubtreeWork is getPostForkWork for the heaviest-subtree (GHOST) fork choice: it returns the work of
+//every block, orphaned or not, descending from the first block after the fork on each side.
+func (blockchain Blockchain) getPostForkSubtreeWork() (mainWork, privWork float64) {
+	mainWork, privWork = 0.0, 0.0
+	if blockchain.forkHeight == 0 {
+		return
+	}
+	subtreeWork := func(root int) (work float64) {
+		inSubtree := map[int]bool{root: true}
+		work = blockchain.tree[root].difficulty
+		//Children always have a larger id than their parent
+		for _, block := range blockchain.tree[root+1:] {
+			if inSubtree[block.parent] {
+				inSubtree[block.id] = true
+				work += block.difficulty
+			}
+		}
+		return
+	}
+	if blockchain.height > blockchain.forkHeight {
+		mainWork = subtreeWork(blockchain.chain[blockchain.forkHeight+1].id)
+	}
+	if len(blockchain.privateBranch) > 0 {
+		privWork = subtreeWork(blockchain.privateBranch[0].id)
+	}
+	return
+}
+
 //setForkHeight sets the height of where a fork occurs.
 //	offset is 0 if we are no longer forking
 //	offset is -1 if we have just forked
@@ -235,6 +380,13 @@ func (blockchain *Blockchain) setForkHeight(offset int) {
 	blockchain.forkHistory = append(blockchain.forkHistory, blockchain.forkHeight)
 }
 
+//forkAt starts a fork below the tip so the private branch competes with the main chain blocks above height.
+func (blockchain *Blockchain) forkAt(height int) {
+	blockchain.forkHeight = height
+	blockchain.forkHistory = append(blockchain.forkHistory, blockchain.forkHeight)
+	blockchain.nextPrivateDifficulty = blockchain.chain[height+1].difficulty
+}
+
 //getPrivateView returns the entire blockchain from the view of the private branch.
 func (blockchain *Blockchain) getPrivateView() []Block {
 	var chain []Block
@@ -246,26 +398,59 @@ func (blockchain *Blockchain) getPrivateView() []Block {
 	return chain
 }
 
-//newBlock creates a new block and pushes it to the chain.
+//newBlock creates a new honest block and pushes it to the chain.
 func (blockchain *Blockchain) newBlock(time int) Block {
-	block := Block{blockchain.height + 1, blockchain.nextDifficulty, time, true}
+	return blockchain.newPublicBlock(time, true)
+}
+
+//newPublicBlock creates a new block mined by either miner and pushes it straight to the chain.
+func (blockchain *Blockchain) newPublicBlock(time int, isHonest bool) Block {
+	parent := blockchain.chain[blockchain.height]
+	if isHonest {
+		time = blockchain.honestTimestamp(time, parent)
+	}
+	fees, backlog := blockchain.rewards.blockFees(parent, blockchain.realTime, 0)
+	block := blockchain.record(Block{height: blockchain.height + 1, difficulty: blockchain.nextDifficulty,
+		timestamp: time, isHonest: isHonest, parent: parent.id, fees: fees, backlog: backlog, minedAt: blockchain.realTime,
+		uncles: blockchain.selectUncles(false)})
 	blockchain.pushToChain(block)
 	blockchain.adjustDifficulty(false)
 	return block
 }
 
-//newPrivateBlock creates a new block and pushes it to the private branch.
-func (blockchain *Blockchain) newPrivateBlock(time int) Block {
-	privBranchLen := len(blockchain.privateBranch)
+//newSiblingBlock creates an honest block competing with the tip for the same parent. It is only recorded in the block tree.
+func (blockchain *Blockchain) newSiblingBlock(time int) Block {
+	tip := blockchain.chain[blockchain.height]
+	parent := blockchain.tree[tip.parent]
+	time = blockchain.honestTimestamp(time, parent)
+	fees, backlog := blockchain.rewards.blockFees(parent, blockchain.realTime, 0)
+	return blockchain.record(Block{height: tip.height, difficulty: tip.difficulty, timestamp: time, isHonest: true,
+		parent: parent.id, fees: fees, backlog: backlog, minedAt: blockchain.realTime, uncles: tip.uncles})
+}
 
-	var newHeight int
-	if privBranchLen > 0 {
-		newHeight = blockchain.privateBranch[privBranchLen-1].height + 1
-	} else {
-		newHeight = blockchain.height + 1
-		//blockchain.setForkHeight(-1)
+//switchTip replaces the tip of the chain with a sibling block, orphaning the tip. Natural forks are not counted as reorgs.
+func (blockchain *Blockchain) switchTip(sibling Block) {
+	blockchain.orphan(blockchain.popFromChain())
+	blockchain.pushToChain(sibling)
+	blockchain.adjustDifficulty(false)
+}
+
+//privateParent returns the block the next private block is mined on.
+func (blockchain *Blockchain) privateParent() Block {
+	if privBranchLen := len(blockchain.privateBranch); privBranchLen > 0 {
+		return blockchain.privateBranch[privBranchLen-1]
 	}
-	block := Block{newHeight, blockchain.nextPrivateDifficulty, time, false}
+	//The private branch starts on top of the fork, which is usually the tip
+	return blockchain.chain[blockchain.forkHeight]
+}
+
+//newPrivateBlock creates a new block and pushes it to the private branch.
+func (blockchain *Blockchain) newPrivateBlock(time int) Block {
+	parent := blockchain.privateParent()
+	fees, backlog := blockchain.rewards.blockFees(parent, blockchain.realTime, blockchain.privateUndercut)
+	block := blockchain.record(Block{height: parent.height + 1, difficulty: blockchain.nextPrivateDifficulty,
+		timestamp: time, isHonest: false, parent: parent.id, fees: fees, backlog: backlog, minedAt: blockchain.realTime,
+		uncles: blockchain.selectUncles(true)})
 	blockchain.pushToPrivateChain(block)
 	blockchain.adjustDifficulty(true)
 	return block
@@ -285,8 +470,9 @@ func (blockchain *Blockchain) reorg() {
 
 	//Remove every block since the fork
 	for i := 0; i < numOrphanBlocks; i++ {
-		blockchain.popFromChain()
+		blockchain.orphan(blockchain.popFromChain())
 	}
+	blockchain.reorgDepths = append(blockchain.reorgDepths, numOrphanBlocks)
 	for len(blockchain.privateBranch) > 0 {
 		block := blockchain.popFromPrivateChainBottom()
 		blockchain.pushToChain(block)
@@ -302,7 +488,8 @@ func (blockchain *Blockchain) reorgRace() {
 		"Main height": blockchain.height,
 		"Priv length": len(blockchain.privateBranch),
 	}).Info("Reorg called")
-	blockchain.popFromChain()
+	blockchain.orphan(blockchain.popFromChain())
+	blockchain.reorgDepths = append(blockchain.reorgDepths, 1)
 	blockchain.pushToChain(blockchain.popFromPrivateChainBottom())
 	blockchain.pushToChain(blockchain.popFromPrivateChainBottom())
 	blockchain.setForkHeight(-1)
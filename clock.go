@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math"
+	"sort"
+
+	"golang.org/x/exp/rand"
+)
+
+//ClockModel describes the clocks of the honest miners, set by -clockoffset, -clockjitter and -clockminers. Each of the
+//Miners honest miners gets a clock offset drawn from Offset at the start of every simulation, and stamps its blocks
+//with its clock plus a draw from Jitter. Honest blocks are found by a miner picked uniformly. Timestamps are never at
+//or before the median time past of the parent, as consensus rules require.
+type ClockModel struct {
+	Miners int          `json:"miners"`
+	Offset Distribution `json:"offset"` //Seconds a miner's clock is ahead, drawn once per miner
+	Jitter Distribution `json:"jitter"` //Seconds added to every honest timestamp
+}
+
+//medianTimePastBlocks is the number of blocks whose median timestamp a new block must be after
+const medianTimePastBlocks = 11
+
+//clockModel is the honest clock model of every simulation of this run, nil for timestamps at the real time
+var clockModel *ClockModel
+
+//offsets draws the clock offset of every honest miner.
+func (clock *ClockModel) offsets() []float64 {
+	if clock == nil {
+		return nil
+	}
+	offsets := make([]float64, clock.Miners)
+	for i := range offsets {
+		offsets[i] = clock.Offset.Rand()
+	}
+	return offsets
+}
+
+//honestTimestamp returns the timestamp an honest miner puts on a block found at the given real time on top of parent.
+func (blockchain *Blockchain) honestTimestamp(time int, parent Block) int {
+	if len(blockchain.clockOffsets) == 0 {
+		return time
+	}
+	offset := blockchain.clockOffsets[rand.Intn(len(blockchain.clockOffsets))]
+	timestamp := time + int(math.Round(offset+clockModel.Jitter.Rand()))
+	if mtp := blockchain.medianTimePast(parent); timestamp <= mtp {
+		timestamp = mtp + 1
+	}
+	return timestamp
+}
+
+//medianTimePast returns the median timestamp of the block and its ancestors, medianTimePastBlocks in all.
+func (blockchain *Blockchain) medianTimePast(block Block) int {
+	var times []int
+	for len(times) < medianTimePastBlocks {
+		times = append(times, block.timestamp)
+		if block.parent < 0 {
+			break
+		}
+		block = blockchain.tree[block.parent]
+	}
+	sort.Ints(times)
+	return times[len(times)/2]
+}
+
+//outOfOrderRate returns the fraction of simulated main chain blocks stamped before their parent.
+func (blockchain *Blockchain) outOfOrderRate() float64 {
+	numBlocks := blockchain.height - blockchain.startingBlocks
+	if numBlocks <= 0 {
+		return 0
+	}
+	outOfOrder := 0
+	for i := blockchain.startingBlocks + 1; i <= blockchain.height; i++ {
+		if blockchain.chain[i].timestamp < blockchain.chain[i-1].timestamp {
+			outOfOrder++
+		}
+	}
+	return float64(outOfOrder) / float64(numBlocks)
+}
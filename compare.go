@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	distuv "gonum.org/v1/gonum/stat/distuv"
+)
+
+//comparisonKey identifies the simulated parameters a result can be aligned on across runs
+type comparisonKey struct {
+	Alpha     float64
+	Gamma     float64
+	Timewarp  int
+	Numblocks int
+}
+
+//comparisonSeries is one run of a results file
+type comparisonSeries struct {
+	label   string
+	results map[comparisonKey]SimulationAvgResults
+}
+
+//Comparison is the difference of a series from the baseline at one point, with Welch's t-test of the means
+type Comparison struct {
+	comparisonKey
+	Series   string
+	Baseline float64
+	Value    float64
+	Diff     float64
+	T        float64
+	P        float64 //Two-sided
+}
+
+//significanceLevel is the p-value below which a difference is flagged as significant
+const significanceLevel = 0.05
+
+//runCompare implements the compare subcommand: the runs of the given results files are aligned on their simulated
+//parameters and compared with the first one, and the profitability threshold of every run is printed.
+func runCompare(args []string) error {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	metric := fs.String("metric", "adjustedrelativegain", "Gain to compare. Options: adjustedrelativegain, relativegain")
+	runsFlag := fs.String("runs", "all", "Runs to compare from every file: all, last, or indexes such as 0,2")
+	csvFile := fs.String("csv", "", "CSV file to write the differences to")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: compare [flags] results.json [more.json...]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	m, ok := gainMetrics[strings.ToLower(*metric)]
+	if !ok {
+		return fmt.Errorf("invalid metric %q", *metric)
+	}
+	files := fs.Args()
+	if len(files) == 0 {
+		files = []string{resultFileName}
+	}
+	series, err := loadComparisonSeries(files, *runsFlag)
+	if err != nil {
+		return err
+	}
+	if len(series) < 2 {
+		return fmt.Errorf("need at least two runs to compare, found %d", len(series))
+	}
+
+	comparisons := compareSeries(series, m)
+	if len(comparisons) == 0 {
+		return fmt.Errorf("no simulated parameters in common with %s", series[0].label)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Alpha\tGamma\tTimewarp\tBlocks\tSeries\t%s\tBaseline (%s)\tDiff\tt\tp\t\n", m.label, series[0].label)
+	for _, c := range comparisons {
+		significant := ""
+		if c.P < significanceLevel {
+			significant = "*"
+		}
+		fmt.Fprintf(w, "%.3f\t%.3f\t%d\t%d\t%s\t%.4f\t%.4f\t%+.4f\t%.2f\t%.4f%s\t\n", c.Alpha, c.Gamma, c.Timewarp, c.Numblocks,
+			c.Series, c.Value, c.Baseline, c.Diff, c.T, c.P, significant)
+	}
+	w.Flush()
+	fmt.Printf("* p < %g (Welch's t-test)\n\n", significanceLevel)
+
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Series\tGamma\tTimewarp\tBlocks\tProfitable from alpha\t\n")
+	for _, s := range series {
+		for _, t := range profitabilityThresholds(s, m) {
+			threshold := "never"
+			if !math.IsNaN(t.alpha) {
+				threshold = fmt.Sprintf("%.4f", t.alpha)
+			}
+			fmt.Fprintf(w, "%s\t%.3f\t%d\t%d\t%s\t\n", s.label, t.key.Gamma, t.key.Timewarp, t.key.Numblocks, threshold)
+		}
+	}
+	w.Flush()
+
+	if *csvFile != "" {
+		return writeComparisons(*csvFile, comparisons)
+	}
+	return nil
+}
+
+//loadComparisonSeries loads the selected runs of every file. Runs are labeled by algo, with the file and run index
+//added when the algo alone is ambiguous.
+func loadComparisonSeries(files []string, selection string) ([]comparisonSeries, error) {
+	type run struct {
+		file  string
+		index int
+		run   ResultsRun
+	}
+	var selected []run
+	for _, file := range files {
+		runs, err := loadResultsRuns(file)
+		if err != nil {
+			return nil, err
+		}
+		var indexes []int
+		switch selection {
+		case "all":
+			for i := range runs {
+				indexes = append(indexes, i)
+			}
+		case "last":
+			indexes = []int{len(runs) - 1}
+		default:
+			for _, part := range strings.Split(selection, ",") {
+				i, err := strconv.Atoi(strings.TrimSpace(part))
+				if err != nil || i < 0 || i >= len(runs) {
+					return nil, fmt.Errorf("invalid run %q for %s with %d runs", part, file, len(runs))
+				}
+				indexes = append(indexes, i)
+			}
+		}
+		for _, i := range indexes {
+			if len(runs[i].Results) > 0 {
+				selected = append(selected, run{file, i, runs[i]})
+			}
+		}
+	}
+
+	algoCount := make(map[string]int)
+	for _, r := range selected {
+		algoCount[r.run.Daa]++
+	}
+	var series []comparisonSeries
+	for _, r := range selected {
+		label := strings.ToUpper(r.run.Daa)
+		if algoCount[r.run.Daa] > 1 {
+			label = fmt.Sprintf("%s (%s#%d)", label, r.file, r.index)
+		}
+		s := comparisonSeries{label: label, results: make(map[comparisonKey]SimulationAvgResults)}
+		for _, res := range r.run.Results {
+			s.results[comparisonKey{res.Alpha, res.Gamma, res.Timewarp, res.Numblocks}] = res
+		}
+		series = append(series, s)
+	}
+	return series, nil
+}
+
+//sortedKeys returns the keys of the results sorted by timewarp, numblocks, gamma then alpha.
+func sortedKeys(results map[comparisonKey]SimulationAvgResults) []comparisonKey {
+	var keys []comparisonKey
+	for key := range results {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.Timewarp != b.Timewarp {
+			return a.Timewarp < b.Timewarp
+		}
+		if a.Numblocks != b.Numblocks {
+			return a.Numblocks < b.Numblocks
+		}
+		if a.Gamma != b.Gamma {
+			return a.Gamma < b.Gamma
+		}
+		return a.Alpha < b.Alpha
+	})
+	return keys
+}
+
+//compareSeries compares every series with the first at the points both simulated.
+func compareSeries(series []comparisonSeries, m gainMetric) []Comparison {
+	var comparisons []Comparison
+	baseline := series[0]
+	for _, key := range sortedKeys(baseline.results) {
+		a := baseline.results[key]
+		for _, s := range series[1:] {
+			b, ok := s.results[key]
+			if !ok {
+				continue
+			}
+			c := Comparison{comparisonKey: key, Series: s.label, Baseline: m.value(a), Value: m.value(b)}
+			c.Diff = c.Value - c.Baseline
+			c.T, c.P = welchTest(c.Diff, m.stdDev(a), a.NumSims, m.stdDev(b), b.NumSims)
+			comparisons = append(comparisons, c)
+		}
+	}
+	return comparisons
+}
+
+//sampleStdDev turns the population standard deviation of n samples, as calcStdDev returns it, into the unbiased
+//sample standard deviation.
+func sampleStdDev(sd float64, n int) float64 {
+	return sd * math.Sqrt(float64(n)/float64(n-1))
+}
+
+//welchTest returns the t statistic and two-sided p-value of a difference of two means, given the population standard
+//deviation and number of samples behind each.
+func welchTest(diff, sdA float64, nA int, sdB float64, nB int) (t, p float64) {
+	if nA < 2 || nB < 2 {
+		return math.NaN(), math.NaN()
+	}
+	sdA, sdB = sampleStdDev(sdA, nA), sampleStdDev(sdB, nB)
+	varA, varB := sdA*sdA/float64(nA), sdB*sdB/float64(nB)
+	se := math.Sqrt(varA + varB)
+	if se == 0 {
+		if diff == 0 {
+			return 0, 1
+		}
+		return math.Inf(int(math.Copysign(1, diff))), 0
+	}
+	t = diff / se
+	df := (varA + varB) * (varA + varB) / (varA*varA/float64(nA-1) + varB*varB/float64(nB-1))
+	p = 2 * distuv.StudentsT{Mu: 0, Sigma: 1, Nu: df}.CDF(-math.Abs(t))
+	return
+}
+
+//profitabilityThreshold is the smallest alpha at which the attack pays for one gamma, timewarp and length
+type profitabilityThreshold struct {
+	key   comparisonKey //Alpha unused
+	alpha float64       //NaN if never profitable
+}
+
+//profitabilityThresholds returns, for every gamma, timewarp and length of the series, the alpha at which the gain
+//becomes positive, interpolated linearly between the simulated alphas.
+func profitabilityThresholds(s comparisonSeries, m gainMetric) []profitabilityThreshold {
+	var thresholds []profitabilityThreshold
+	keys := sortedKeys(s.results)
+	for start := 0; start < len(keys); {
+		end := start
+		group := keys[start]
+		group.Alpha = 0
+		for end < len(keys) && keys[end].Gamma == group.Gamma && keys[end].Timewarp == group.Timewarp && keys[end].Numblocks == group.Numblocks {
+			end++
+		}
+
+		t := profitabilityThreshold{key: group, alpha: math.NaN()}
+		for i := start; i < end; i++ {
+			gain := m.value(s.results[keys[i]])
+			if gain <= 0 {
+				continue
+			}
+			t.alpha = keys[i].Alpha
+			if i > start {
+				prevGain := m.value(s.results[keys[i-1]])
+				t.alpha = keys[i-1].Alpha + (keys[i].Alpha-keys[i-1].Alpha)*(-prevGain)/(gain-prevGain)
+			}
+			break
+		}
+		thresholds = append(thresholds, t)
+		start = end
+	}
+	return thresholds
+}
+
+//writeComparisons writes the differences to a CSV file.
+func writeComparisons(fileName string, comparisons []Comparison) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"alpha", "gamma", "timewarp", "numblocks", "series", "value", "baseline", "diff", "t", "p"})
+	for _, c := range comparisons {
+		w.Write([]string{
+			strconv.FormatFloat(c.Alpha, 'g', -1, 64), strconv.FormatFloat(c.Gamma, 'g', -1, 64),
+			strconv.Itoa(c.Timewarp), strconv.Itoa(c.Numblocks), c.Series,
+			strconv.FormatFloat(c.Value, 'g', -1, 64), strconv.FormatFloat(c.Baseline, 'g', -1, 64),
+			strconv.FormatFloat(c.Diff, 'g', -1, 64), strconv.FormatFloat(c.T, 'g', -1, 64), strconv.FormatFloat(c.P, 'g', -1, 64),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"math"
 	"sort"
 
 	log "github.com/sirupsen/logrus"
@@ -14,6 +15,39 @@ type Difficulty interface {
 	//Parse(data []byte) error
 }
 
+func init() {
+	registerDifficulty(DifficultyDef{Name: "btc", BlockTime: 600, Params: btcDifficulty{Period: 2016, OffByOne: true},
+		MinHistory: btcHistory})
+	registerDifficulty(DifficultyDef{Name: "bch", BlockTime: 600, Params: bchDifficulty{Lookback: 144, OffByOne: true, Mediantimepast: 3},
+		MinHistory: bchHistory})
+	registerDifficulty(DifficultyDef{Name: "eda", BlockTime: 600, Params: edaDifficulty{btcDifficulty: btcDifficulty{Period: 2016, OffByOne: true},
+		Mediantimepast: 11, EmergencyBlocks: 6, EmergencyTime: 12 * 3600, EmergencyDrop: 0.2}, MinHistory: edaHistory})
+	registerDifficulty(DifficultyDef{Name: "dash", BlockTime: 150, Params: dashDifficulty{NPastBlocks: 24, OffByOne: true},
+		MinHistory: dashHistory})
+	registerDifficulty(DifficultyDef{Name: "kgw", BlockTime: 150, Params: kgwDifficulty{PastBlocksMin: 14, PastBlocksMax: 4032,
+		EventHorizonFactor: 0.7084, EventHorizonBlocks: 144, EventHorizonExponent: -1.228}})
+	registerDifficulty(DifficultyDef{Name: "dgw1", BlockTime: 150, Params: dgwDifficulty{PastBlocksMin: 12, PastBlocksMax: 120}})
+	registerDifficulty(DifficultyDef{Name: "dgw2", BlockTime: 150, Params: dgwDifficulty{PastBlocksMin: 14, PastBlocksMax: 140}})
+	registerDifficulty(DifficultyDef{Name: "xmr", BlockTime: 120, Params: xmrDifficulty{Lookback: 720, Delay: 15, Outliers: 60},
+		MinHistory: xmrHistory})
+	registerDifficulty(DifficultyDef{Name: "zec", BlockTime: 150, Params: digishieldDifficulty{NAveragingInterval: 17, NMedianTimespan: 11,
+		NMaxAdjustUp: 16, NMaxAdjustDown: 32, NPOWDampeningFactor: 4.0}, MinHistory: digishieldHistory})
+	registerDifficulty(DifficultyDef{Name: "doge", BlockTime: 60, Params: digishieldDifficulty{NAveragingInterval: 1, NMedianTimespan: 1,
+		NMaxAdjustUp: 25, NMaxAdjustDown: 50, NPOWDampeningFactor: 8.0}, MinHistory: digishieldHistory})
+	registerDifficulty(DifficultyDef{Name: "btg", BlockTime: 600, Params: digishieldDifficulty{NAveragingInterval: 30, NMedianTimespan: 11,
+		NMaxAdjustUp: 16, NMaxAdjustDown: 32, NPOWDampeningFactor: 4.0}, MinHistory: digishieldHistory})
+	registerDifficulty(DifficultyDef{Name: "ema", BlockTime: 600, Params: emaDifficulty{N: 100, MinSolvetime: -6, MaxSolvetime: 6}})
+	registerDifficulty(DifficultyDef{Name: "wtema", BlockTime: 600, Params: wtemaDifficulty{N: 100, MinSolvetime: -6, MaxSolvetime: 6}})
+	//ASERT with a 12 hour half-life, 144 blocks of 5 minutes
+	registerDifficulty(DifficultyDef{Name: "dcr", BlockTime: 300, Params: emaDifficulty{N: 144 / math.Ln2, MinSolvetime: -6, MaxSolvetime: 6}})
+	//Close to the damped moving average of Grin, a 60 block window damped by 3
+	registerDifficulty(DifficultyDef{Name: "grin", BlockTime: 60, Params: wtemaDifficulty{N: 90, MinSolvetime: -6, MaxSolvetime: 6}})
+	registerDifficulty(DifficultyDef{Name: "eth", BlockTime: 13, Params: ethDifficulty{Byzantium: true, BoundDivisor: 2048, DurationLimit: 9,
+		MaxAdjustDown: 99}})
+	registerDifficulty(DifficultyDef{Name: "etc", BlockTime: 13, Params: ethDifficulty{Byzantium: false, BoundDivisor: 2048, DurationLimit: 10,
+		MaxAdjustDown: 99}})
+}
+
 //this can be optimized by using sort.Sort and using the timestamp
 //as parameter
 //https://stackoverflow.com/questions/36122668/golang-how-to-sort-struct-with-multiple-sort-parameters
@@ -102,6 +136,12 @@ func (b bchDifficulty) getDiff(isPrivate bool, blockchain Blockchain) float64 {
 	return newDiff
 }
 
+//bchHistory is the lookback and the median time past of its first block.
+func bchHistory(params Difficulty) int {
+	b := params.(bchDifficulty)
+	return b.Lookback + b.Mediantimepast
+}
+
 type btcDifficulty struct {
 	Period   int  `yaml:"period" json:"period"`
 	OffByOne bool `yaml:"offbyone" json:"offbyone"`
@@ -117,7 +157,7 @@ func (b btcDifficulty) getDiff(isPrivate bool, blockchain Blockchain) float64 {
 	}
 	chainLen := len(chain)
 
-	if (chainLen-STARTING_BLOCKS)%b.Period != 0 {
+	if (chainLen-blockchain.startingBlocks)%b.Period != 0 {
 		return chain[chainLen-1].difficulty
 	}
 	top := chain[chainLen-1]
@@ -139,6 +179,58 @@ func (b btcDifficulty) getDiff(isPrivate bool, blockchain Blockchain) float64 {
 	return newDiff
 }
 
+//btcHistory is the period and the block before it at a retarget.
+func btcHistory(params Difficulty) int {
+	return params.(btcDifficulty).Period + 1
+}
+
+//edaDifficulty is the Bitcoin retarget with the Emergency Difficulty Adjustment Bitcoin Cash ran from August to
+//November 2017: between retargets, if the median time past of the tip is more than EmergencyTime seconds after the
+//median time past EmergencyBlocks blocks earlier, the difficulty drops by EmergencyDrop (20%) for the next block.
+type edaDifficulty struct {
+	btcDifficulty   `yaml:",inline"`
+	Mediantimepast  int     `yaml:"mediantimepast" json:"mediantimepast"`   //11
+	EmergencyBlocks int     `yaml:"emergencyblocks" json:"emergencyblocks"` //6
+	EmergencyTime   int     `yaml:"emergencytime" json:"emergencytime"`     //12 hours
+	EmergencyDrop   float64 `yaml:"emergencydrop" json:"emergencydrop"`     //0.2, target raised by a quarter
+}
+
+func (e edaDifficulty) getDiff(isPrivate bool, blockchain Blockchain) float64 {
+	var chain []Block
+	if isPrivate {
+		chain = blockchain.getPrivateView()
+	} else {
+		chain = blockchain.chain
+	}
+	chainLen := len(chain)
+
+	if (chainLen-blockchain.startingBlocks)%e.Period == 0 {
+		return e.btcDifficulty.getDiff(isPrivate, blockchain)
+	}
+
+	tipMTP := median(chain[chainLen-e.Mediantimepast : chainLen])
+	pastMTP := median(chain[chainLen-e.EmergencyBlocks-e.Mediantimepast : chainLen-e.EmergencyBlocks])
+	newDiff := chain[chainLen-1].difficulty
+	if tipMTP.timestamp-pastMTP.timestamp > e.EmergencyTime {
+		newDiff *= 1 - e.EmergencyDrop
+		log.WithFields(log.Fields{
+			"Height":   chainLen,
+			"MTP span": tipMTP.timestamp - pastMTP.timestamp,
+			"NewDiff":  newDiff,
+		}).Info("Emergency difficulty adjustment")
+	}
+	return newDiff
+}
+
+//edaHistory is the longest of the retarget period and the median time past EmergencyBlocks blocks back.
+func edaHistory(params Difficulty) int {
+	e := params.(edaDifficulty)
+	if e.EmergencyBlocks+e.Mediantimepast > e.Period+1 {
+		return e.EmergencyBlocks + e.Mediantimepast
+	}
+	return e.Period + 1
+}
+
 type dashDifficulty struct {
 	NPastBlocks int  `yaml:"npastblocks" json:"npastblocks"`
 	OffByOne    bool `yaml:"offbyone" json:"offbyone"`
@@ -191,6 +283,141 @@ func (d dashDifficulty) getDiff(isPrivate bool, blockchain Blockchain) float64 {
 	return 1.0 / bnNew
 }
 
+//dashHistory is the past blocks, and the block before them unless OffByOne.
+func dashHistory(params Difficulty) int {
+	d := params.(dashDifficulty)
+	if d.OffByOne {
+		return d.NPastBlocks
+	}
+	return d.NPastBlocks + 1
+}
+
+//kgwDifficulty is the Kimoto Gravity Well (Megacoin, early Dash). Targets are averaged over a window that grows one
+//block at a time until the observed block rate leaves the event horizon, 1 + EventHorizonFactor *
+//(blocks / EventHorizonBlocks)^EventHorizonExponent, or PastBlocksMax blocks are reached.
+type kgwDifficulty struct {
+	PastBlocksMin        int     `yaml:"pastblocksmin" json:"pastblocksmin"`               //14, 0.025 days of Dash blocks
+	PastBlocksMax        int     `yaml:"pastblocksmax" json:"pastblocksmax"`               //4032, 7 days of Dash blocks
+	EventHorizonFactor   float64 `yaml:"eventhorizonfactor" json:"eventhorizonfactor"`     //0.7084
+	EventHorizonBlocks   float64 `yaml:"eventhorizonblocks" json:"eventhorizonblocks"`     //144
+	EventHorizonExponent float64 `yaml:"eventhorizonexponent" json:"eventhorizonexponent"` //-1.228
+}
+
+func (k kgwDifficulty) getDiff(isPrivate bool, blockchain Blockchain) float64 {
+	var chain []Block
+	if isPrivate {
+		chain = blockchain.getPrivateView()
+	} else {
+		chain = blockchain.chain
+	}
+
+	last := chain[len(chain)-1]
+	var pastTargetAvg float64
+	var pastRateActualSeconds, pastRateTargetSeconds int
+	for i := 1; len(chain)-i > 0; i++ {
+		if k.PastBlocksMax > 0 && i > k.PastBlocksMax {
+			break
+		}
+		reading := chain[len(chain)-i]
+		if i == 1 {
+			pastTargetAvg = 1.0 / reading.difficulty
+		} else {
+			pastTargetAvg += (1.0/reading.difficulty - pastTargetAvg) / float64(i)
+		}
+
+		pastRateActualSeconds = last.timestamp - reading.timestamp
+		pastRateTargetSeconds = blockchain.expectedBlockTime * i
+		if pastRateActualSeconds < 0 {
+			pastRateActualSeconds = 0
+		}
+		pastRateAdjustmentRatio := 1.0
+		if pastRateActualSeconds != 0 {
+			pastRateAdjustmentRatio = float64(pastRateTargetSeconds) / float64(pastRateActualSeconds)
+		}
+
+		eventHorizonDeviation := 1 + k.EventHorizonFactor*math.Pow(float64(i)/k.EventHorizonBlocks, k.EventHorizonExponent)
+		if i >= k.PastBlocksMin && (pastRateAdjustmentRatio <= 1/eventHorizonDeviation || pastRateAdjustmentRatio >= eventHorizonDeviation) {
+			break
+		}
+	}
+
+	bnNew := pastTargetAvg
+	if pastRateActualSeconds != 0 {
+		bnNew *= float64(pastRateActualSeconds) / float64(pastRateTargetSeconds)
+	}
+	return 1.0 / bnNew
+}
+
+//dgwDifficulty is DarkGravityWave v1 and v2, the predecessors of the DGWv3 in dashDifficulty, which only differ by
+//their windows. Targets are averaged over the last PastBlocksMin blocks. The block time is a "SmartAverage": 0.7 times
+//the running mean of the most recent PastBlocksMin+1 solvetimes plus 0.3 times the mean solvetime over the last
+//PastBlocksMax blocks, and the timespan of the blocks read at that block time is clamped to 1/3-3 times the target.
+type dgwDifficulty struct {
+	PastBlocksMin int `yaml:"pastblocksmin" json:"pastblocksmin"` //12 for v1, 14 for v2
+	PastBlocksMax int `yaml:"pastblocksmax" json:"pastblocksmax"` //120 for v1, 140 for v2
+}
+
+func (d dgwDifficulty) getDiff(isPrivate bool, blockchain Blockchain) float64 {
+	var chain []Block
+	if isPrivate {
+		chain = blockchain.getPrivateView()
+	} else {
+		chain = blockchain.chain
+	}
+
+	var pastTargetAvg float64
+	var blockTimeAverage, blockTimeCount, blockTimeSum2, blockTimeCount2, lastBlockTime, countBlocks int
+	for i := 1; len(chain)-i > 0; i++ {
+		if d.PastBlocksMax > 0 && i > d.PastBlocksMax {
+			break
+		}
+		reading := chain[len(chain)-i]
+		countBlocks++
+		if countBlocks <= d.PastBlocksMin {
+			target := 1.0 / reading.difficulty
+			if countBlocks == 1 {
+				pastTargetAvg = target
+			} else {
+				pastTargetAvg += (target - pastTargetAvg) / float64(countBlocks)
+			}
+		}
+		if i > 1 {
+			diff := lastBlockTime - reading.timestamp
+			//The count is checked before it is incremented, so PastBlocksMin+1 solvetimes are averaged, with
+			//integer division as in the original
+			if blockTimeCount <= d.PastBlocksMin {
+				blockTimeCount++
+				if blockTimeCount == 1 {
+					blockTimeAverage = diff
+				} else {
+					blockTimeAverage += (diff - blockTimeAverage) / blockTimeCount
+				}
+			}
+			blockTimeCount2++
+			blockTimeSum2 += diff
+		}
+		lastBlockTime = reading.timestamp
+	}
+
+	if blockTimeCount == 0 || blockTimeCount2 == 0 {
+		return 1.0 / pastTargetAvg
+	}
+	smartAverage := float64(blockTimeAverage)*0.7 + float64(blockTimeSum2)/float64(blockTimeCount2)*0.3
+	if smartAverage < 1 {
+		smartAverage = 1
+	}
+	targetTimespan := float64(countBlocks * blockchain.expectedBlockTime)
+	actualTimespan := float64(countBlocks) * smartAverage
+	if actualTimespan < targetTimespan/3 {
+		actualTimespan = targetTimespan / 3
+	} else if actualTimespan > targetTimespan*3 {
+		actualTimespan = targetTimespan * 3
+	}
+
+	bnNew := pastTargetAvg * math.Trunc(actualTimespan) / math.Trunc(targetTimespan)
+	return 1.0 / bnNew
+}
+
 type xmrDifficulty struct {
 	Lookback int `yaml:"lookback" json:"lookback"`
 	Delay    int `yaml:"delay" json:"delay"`
@@ -241,15 +468,25 @@ func (x xmrDifficulty) getDiff(isPrivate bool, blockchain Blockchain) float64 {
 	return newDiff
 }
 
-type zecDifficulty struct {
+//xmrHistory is the lookback, the delayed blocks and the tip.
+func xmrHistory(params Difficulty) int {
+	x := params.(xmrDifficulty)
+	return x.Lookback + x.Delay + 1
+}
+
+//digishieldDifficulty is Digishield v3 with all of its knobs, as run with different parameters by ZEC, DOGE and BTG.
+//The median time past span of the last NAveragingInterval blocks is dampened towards the target and clamped to
+//NMaxAdjustUp/NMaxAdjustDown percent of it, then scales the average target (or difficulty) of those blocks.
+type digishieldDifficulty struct {
 	NAveragingInterval  int     `yaml:"navginterval" json:"navginterval"`
-	NMedianTimespan     int     `yaml:"nmediantimespan" json:"nmediantimespan"`
-	NMaxAdjustUp        int     `yaml:"nmaxadjustup" json:"nmaxadjustup"`
-	NMaxAdjustDown      int     `yaml:"nmaxadjustdown" json:"nmaxadjustdown"`
-	NPOWDampeningFactor float64 `yaml:"npowdampeningfactor" jsob:"npowdampeningfactor"`
+	NMedianTimespan     int     `yaml:"nmediantimespan" json:"nmediantimespan"`         //1 uses the timestamps of A and B themselves
+	NMaxAdjustUp        int     `yaml:"nmaxadjustup" json:"nmaxadjustup"`               //Percent the timespan may fall below the target
+	NMaxAdjustDown      int     `yaml:"nmaxadjustdown" json:"nmaxadjustdown"`           //Percent the timespan may exceed the target
+	NPOWDampeningFactor float64 `yaml:"npowdampeningfactor" json:"npowdampeningfactor"` //Only 1/factor of the deviation is applied
+	AverageDifficulties bool    `yaml:"averagedifficulties" json:"averagedifficulties"` //Average difficulties instead of targets
 }
 
-func (z zecDifficulty) getDiff(isPrivate bool, blockchain Blockchain) float64 {
+func (z digishieldDifficulty) getDiff(isPrivate bool, blockchain Blockchain) float64 {
 	var chain []Block
 	if isPrivate {
 		chain = blockchain.getPrivateView()
@@ -270,10 +507,10 @@ func (z zecDifficulty) getDiff(isPrivate bool, blockchain Blockchain) float64 {
 	A := chain[len(chain)-1-nAveragingInterval]
 
 	//get median of past 11 (including B)
-	bMedian := median(chain[B.height-nMediantimespan : B.height])
+	bMedian := median(chain[B.height-nMediantimespan+1 : B.height+1])
 
 	//get median of past 11 (including A)
-	aMedian := median(chain[A.height-nMediantimespan : A.height])
+	aMedian := median(chain[A.height-nMediantimespan+1 : A.height+1])
 
 	nActualTimespan := bMedian.timestamp - aMedian.timestamp
 	nActualTimespanf := float64(nAveragingTargetTimespan) + float64(nActualTimespan-nAveragingTargetTimespan)/nPOWDampeningFactor
@@ -284,8 +521,13 @@ func (z zecDifficulty) getDiff(isPrivate bool, blockchain Blockchain) float64 {
 		nActualTimespanf = nMaxActualTimespanV3
 	}
 
+	if z.AverageDifficulties {
+		avgDiff := sumBlocks(chain[B.height-nAveragingInterval+1:B.height+1]...) / float64(nAveragingInterval)
+		return avgDiff * float64(nAveragingTargetTimespan) / nActualTimespanf
+	}
+
 	nAvgTarget := 0.0
-	for _, block := range chain[B.height-nAveragingInterval : B.height] {
+	for _, block := range chain[B.height-nAveragingInterval+1 : B.height+1] {
 		//nAvgTarget += block.difficulty
 		nAvgTarget += float64(1.0) / float64(block.difficulty)
 	}
@@ -294,6 +536,11 @@ func (z zecDifficulty) getDiff(isPrivate bool, blockchain Blockchain) float64 {
 	bnNew := nAvgTarget / float64(nAveragingTargetTimespan)
 	bnNew = bnNew * nActualTimespanf
 
+	//The log shows up to the last 30 blocks, fewer when the history is shorter
+	top := len(chain) - 30
+	if top < 0 {
+		top = 0
+	}
 	//fmt.Printf("--------------------------------ZEC DIFF--------------------------------------------\n")
 	log.WithFields(log.Fields{
 		"B":                B,
@@ -305,8 +552,95 @@ func (z zecDifficulty) getDiff(isPrivate bool, blockchain Blockchain) float64 {
 		"nAvgTarget":       nAvgTarget,
 		"bnNew":            bnNew,
 		"1/bnNew":          float64(1.0) / float64(bnNew),
-		"TOP30":            chain[len(chain)-30:],
+		"TOP30":            chain[top:],
 	}).Info("Difficulty Change")
 
 	return 1.0 / bnNew
 }
+
+//digishieldHistory is the averaging interval and the median time past of the block before it.
+func digishieldHistory(params Difficulty) int {
+	z := params.(digishieldDifficulty)
+	return z.NAveragingInterval + z.NMedianTimespan
+}
+
+//tipSolvetime returns the solvetime of the tip of the chain in block times, clamped to [min, max].
+func tipSolvetime(chain []Block, expectedBlockTime int, min, max float64) float64 {
+	solvetime := float64(chain[len(chain)-1].timestamp-chain[len(chain)-2].timestamp) / float64(expectedBlockTime)
+	return math.Max(min, math.Min(max, solvetime))
+}
+
+//emaDifficulty is an exponential moving average of the solvetimes (Eliosoff's EMA, equivalent to ASERT as used by
+//Decred): every block scales the difficulty of the tip by e^((1 - solvetime/T) / N), T being the expected block time.
+type emaDifficulty struct {
+	N            float64 `yaml:"n" json:"n"`                       //Smoothing constant, mean lifetime in blocks
+	MinSolvetime float64 `yaml:"minsolvetime" json:"minsolvetime"` //Clamp, in block times
+	MaxSolvetime float64 `yaml:"maxsolvetime" json:"maxsolvetime"` //Clamp, in block times
+}
+
+func (e emaDifficulty) getDiff(isPrivate bool, blockchain Blockchain) float64 {
+	var chain []Block
+	if isPrivate {
+		chain = blockchain.getPrivateView()
+	} else {
+		chain = blockchain.chain
+	}
+
+	solvetime := tipSolvetime(chain, blockchain.expectedBlockTime, e.MinSolvetime, e.MaxSolvetime)
+	return chain[len(chain)-1].difficulty * math.Exp((1-solvetime)/e.N)
+}
+
+//wtemaDifficulty is the weighted-target EMA: every block scales the target of the tip by 1 + (solvetime/T - 1) / N.
+//It is the first order approximation of emaDifficulty and behaves much like the damped moving averages of Grin and Kaspa.
+type wtemaDifficulty struct {
+	N            float64 `yaml:"n" json:"n"`                       //Smoothing constant, mean lifetime in blocks
+	MinSolvetime float64 `yaml:"minsolvetime" json:"minsolvetime"` //Clamp, in block times. Must stay above 1 - N
+	MaxSolvetime float64 `yaml:"maxsolvetime" json:"maxsolvetime"` //Clamp, in block times
+}
+
+func (w wtemaDifficulty) getDiff(isPrivate bool, blockchain Blockchain) float64 {
+	var chain []Block
+	if isPrivate {
+		chain = blockchain.getPrivateView()
+	} else {
+		chain = blockchain.chain
+	}
+
+	solvetime := tipSolvetime(chain, blockchain.expectedBlockTime, w.MinSolvetime, w.MaxSolvetime)
+	return chain[len(chain)-1].difficulty / (1 + (solvetime-1)/w.N)
+}
+
+//ethDifficulty is the Ethereum Homestead (EIP-2) and Byzantium (EIP-100) difficulty adjustment.
+//Ethereum adjusts using the timestamp of the block being mined, which is unknown before it is found, so
+//here the solvetime of the tip (timestamp of the tip minus that of its parent) is used instead.
+//The difficulty bomb is ignored.
+type ethDifficulty struct {
+	Byzantium     bool `yaml:"byzantium" json:"byzantium"`         //Count uncles of the parent (EIP-100)
+	BoundDivisor  int  `yaml:"bounddivisor" json:"bounddivisor"`   //2048
+	DurationLimit int  `yaml:"durationlimit" json:"durationlimit"` //10 for Homestead, 9 for Byzantium
+	MaxAdjustDown int  `yaml:"maxadjustdown" json:"maxadjustdown"` //99
+}
+
+func (e ethDifficulty) getDiff(isPrivate bool, blockchain Blockchain) float64 {
+	var chain []Block
+	if isPrivate {
+		chain = blockchain.getPrivateView()
+	} else {
+		chain = blockchain.chain
+	}
+
+	parent := chain[len(chain)-1]
+	grandparent := chain[len(chain)-2]
+	solveTime := float64(parent.timestamp - grandparent.timestamp)
+
+	sigma := 1.0
+	if e.Byzantium && len(parent.uncles) > 0 {
+		sigma = 2.0
+	}
+	sigma -= math.Floor(solveTime / float64(e.DurationLimit))
+	if sigma < -float64(e.MaxAdjustDown) {
+		sigma = -float64(e.MaxAdjustDown)
+	}
+
+	return parent.difficulty + parent.difficulty/float64(e.BoundDivisor)*sigma
+}
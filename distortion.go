@@ -0,0 +1,132 @@
+package main
+
+import (
+	"math"
+)
+
+//recoveryTolerance is how far, relative to the equilibrium, the mean difficulty of the recoveryBlocks blocks after a
+//block may be for the difficulty to have recovered at that block
+const recoveryTolerance = 0.05
+const recoveryBlocks = 144
+
+//ChainDistortion holds how the chain as a whole deviates from its schedule
+type ChainDistortion struct {
+	MeanBlockInterval      float64 `json:"meanblockinterval"`      //Mean real seconds between main chain blocks
+	BlockIntervalVariance  float64 `json:"blockintervalvariance"`  //Variance of the real seconds between main chain blocks
+	CoinsEmitted           float64 `json:"coinsemitted"`           //Subsidy emitted by the simulated main chain blocks
+	ScheduledEmission      float64 `json:"scheduledemission"`      //Subsidy one block per expected block time would have emitted
+	EmissionRatio          float64 `json:"emissionratio"`          //CoinsEmitted / ScheduledEmission
+	DifficultyDeviation    float64 `json:"difficultydeviation"`    //Mean relative deviation of the difficulty from the total hashrate
+	DifficultyDeviationRMS float64 `json:"difficultydeviationrms"` //Root mean square of the same
+	RecoveryTime           float64 `json:"recoverytime"`           //Seconds from the end of the attack to the difficulty recovering, -1 if it never did
+}
+
+//scheduledEmission returns the subsidy emitted by the given number of blocks from StartHeight, the last one counted
+//by its fraction.
+func (r RewardModel) scheduledEmission(blocks float64) float64 {
+	emitted := r.StartEmitted
+	for i := 0; float64(i) < blocks; i++ {
+		emitted += r.subsidy(r.StartHeight+i, emitted) * math.Min(1, blocks-float64(i))
+	}
+	return emitted - r.StartEmitted
+}
+
+//chainDistortion measures the block intervals, emission and difficulty of the simulated main chain. emitted is the
+//subsidy emitted by the simulated blocks.
+func (sim *Simulation) chainDistortion(emitted float64) ChainDistortion {
+	d := ChainDistortion{CoinsEmitted: emitted, RecoveryTime: -1}
+	chain := sim.blockchain.chain[sim.blockchain.startingBlocks:]
+
+	var intervals []float64
+	for i := 1; i < len(chain); i++ {
+		intervals = append(intervals, float64(chain[i].minedAt-chain[i-1].minedAt))
+	}
+	if len(intervals) > 0 {
+		d.MeanBlockInterval = sum(intervals...) / float64(len(intervals))
+		for _, interval := range intervals {
+			d.BlockIntervalVariance += (interval - d.MeanBlockInterval) * (interval - d.MeanBlockInterval)
+		}
+		d.BlockIntervalVariance /= float64(len(intervals))
+	}
+
+	elapsed := sim.realTime - sim.startTime
+	d.ScheduledEmission = sim.blockchain.rewards.scheduledEmission(float64(elapsed) / float64(sim.expectedBlockTime))
+	if d.ScheduledEmission > 0 {
+		d.EmissionRatio = d.CoinsEmitted / d.ScheduledEmission
+	}
+
+	//The equilibrium difficulty is the total hashrate when the block was mined, 1 being the starting hashrate. It is
+	//undefined for blocks mined while the schedules leave no hashrate, which are left out
+	deviations := make([]float64, len(chain))
+	valid := make([]bool, len(chain))
+	squares, measured := 0.0, 0.0
+	for i, block := range chain {
+		honest, selfish := sim.hashratesAt(block.minedAt)
+		if honest+selfish <= 0 {
+			continue
+		}
+		deviations[i] = block.difficulty/(honest+selfish) - 1
+		valid[i] = true
+		squares += deviations[i] * deviations[i]
+		measured++
+	}
+	if measured > 0 {
+		d.DifficultyDeviation = sum(deviations...) / measured
+		d.DifficultyDeviationRMS = math.Sqrt(squares / measured)
+	}
+
+	//The attack only ends with -phases, so the recovery time stays -1 otherwise
+	if sim.attackEnd > 0 {
+		for i, block := range chain {
+			if block.minedAt < sim.attackEnd || i+recoveryBlocks > len(chain) {
+				continue
+			}
+			total, n := 0.0, 0.0
+			for j := i; j < i+recoveryBlocks; j++ {
+				if valid[j] {
+					total += deviations[j]
+					n++
+				}
+			}
+			if n > 0 && math.Abs(total/n) <= recoveryTolerance {
+				d.RecoveryTime = float64(block.minedAt - sim.attackEnd)
+				break
+			}
+		}
+	}
+	return d
+}
+
+//averageDistortion averages the distortion of several simulations. The recovery time is averaged over the
+//simulations that recovered.
+func averageDistortion(results []SimulationResult) (avg ChainDistortion) {
+	recovered := 0.0
+	for _, res := range results {
+		d := res.ChainDistortion
+		avg.MeanBlockInterval += d.MeanBlockInterval
+		avg.BlockIntervalVariance += d.BlockIntervalVariance
+		avg.CoinsEmitted += d.CoinsEmitted
+		avg.ScheduledEmission += d.ScheduledEmission
+		avg.EmissionRatio += d.EmissionRatio
+		avg.DifficultyDeviation += d.DifficultyDeviation
+		avg.DifficultyDeviationRMS += d.DifficultyDeviationRMS
+		if d.RecoveryTime >= 0 {
+			avg.RecoveryTime += d.RecoveryTime
+			recovered++
+		}
+	}
+	n := float64(len(results))
+	avg.MeanBlockInterval /= n
+	avg.BlockIntervalVariance /= n
+	avg.CoinsEmitted /= n
+	avg.ScheduledEmission /= n
+	avg.EmissionRatio /= n
+	avg.DifficultyDeviation /= n
+	avg.DifficultyDeviationRMS /= n
+	if recovered > 0 {
+		avg.RecoveryTime /= recovered
+	} else {
+		avg.RecoveryTime = -1
+	}
+	return
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	distuv "gonum.org/v1/gonum/stat/distuv"
+)
+
+//Distribution is a random variable described on the command line or in YAML by a spec such as "exp:0.5"
+//	const:x			always x
+//	exp:mean		exponential with the given mean
+//	normal:mean:sd		normal
+//	lognormal:mu:sigma	log-normal, mu and sigma of the underlying normal
+//	uniform:min:max		uniform
+type Distribution struct {
+	Spec string
+	rand func() float64
+	mean float64
+}
+
+//parseDistribution parses a distribution spec.
+func parseDistribution(spec string) (Distribution, error) {
+	parts := strings.Split(strings.ToLower(strings.TrimSpace(spec)), ":")
+	var params []float64
+	for _, p := range parts[1:] {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return Distribution{}, fmt.Errorf("invalid distribution parameter %q in %q", p, spec)
+		}
+		params = append(params, v)
+	}
+
+	numParams := map[string]int{"const": 1, "exp": 1, "normal": 2, "lognormal": 2, "uniform": 2}
+	if n, ok := numParams[parts[0]]; !ok || n != len(params) {
+		return Distribution{}, fmt.Errorf("invalid distribution %q", spec)
+	}
+
+	d := Distribution{Spec: spec}
+	switch parts[0] {
+	case "const":
+		d.mean = params[0]
+		d.rand = func() float64 { return params[0] }
+	case "exp":
+		if params[0] <= 0 {
+			return Distribution{}, fmt.Errorf("exponential mean must be positive in %q", spec)
+		}
+		dist := distuv.Exponential{Rate: 1.0 / params[0]}
+		d.mean = params[0]
+		d.rand = dist.Rand
+	case "normal":
+		dist := distuv.Normal{Mu: params[0], Sigma: params[1]}
+		d.mean = params[0]
+		d.rand = dist.Rand
+	case "lognormal":
+		dist := distuv.LogNormal{Mu: params[0], Sigma: params[1]}
+		d.mean = dist.Mean()
+		d.rand = dist.Rand
+	case "uniform":
+		dist := distuv.Uniform{Min: params[0], Max: params[1]}
+		d.mean = dist.Mean()
+		d.rand = dist.Rand
+	}
+	return d, nil
+}
+
+//Rand draws a value from the distribution, 0 if it was never set.
+func (d Distribution) Rand() float64 {
+	if d.rand == nil {
+		return 0
+	}
+	return d.rand()
+}
+
+//Mean returns the mean of the distribution
+func (d Distribution) Mean() float64 {
+	return d.mean
+}
+
+//UnmarshalYAML lets distributions be written as their spec in YAML files
+func (d *Distribution) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var spec string
+	if err := unmarshal(&spec); err != nil {
+		return err
+	}
+	parsed, err := parseDistribution(spec)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+//MarshalJSON saves distributions as their spec
+func (d Distribution) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(d.Spec)), nil
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+//columnNames renames JSON tags that are misspelled in results.json, which keeps them for compatibility
+var columnNames = map[string]string{"adjustedgainsteddev": "adjustedgainstddev"}
+
+//resultsTable is the results of a run flattened to one row per parameter point. Nested fields are named by their
+//path, such as timestampattack_sharegain or miners_0_winratio.
+type resultsTable struct {
+	columns    []string
+	kinds      map[string]reflect.Kind //Float64, Int64, Bool or String
+	rows       []map[string]interface{}
+	mapColumns map[string]mapColumn //Columns holding the value of a map key, such as reorgdepths_2
+}
+
+//mapColumn is the map a column comes from and its key in that map.
+type mapColumn struct {
+	prefix string
+	key    reflect.Value
+}
+
+//lessKey orders map keys, integer keys by value.
+func lessKey(a, b reflect.Value) bool {
+	if a.Kind() == reflect.Int && b.Kind() == reflect.Int {
+		return a.Int() < b.Int()
+	}
+	return fmt.Sprint(a) < fmt.Sprint(b)
+}
+
+//add sets a column of a row, registering the column the first time it is seen.
+func (t *resultsTable) add(row map[string]interface{}, name string, value interface{}, kind reflect.Kind) {
+	if _, ok := t.kinds[name]; !ok {
+		t.columns = append(t.columns, name)
+		t.kinds[name] = kind
+	}
+	row[name] = value
+}
+
+//flatten adds the scalar fields of v to the row, struct fields being named by their JSON tag.
+func (t *resultsTable) flatten(row map[string]interface{}, prefix string, v reflect.Value) {
+	join := func(name string) string {
+		if prefix == "" {
+			return name
+		}
+		return prefix + "_" + name
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			t.flatten(row, prefix, v.Elem())
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "-" || (field.PkgPath != "" && !field.Anonymous) {
+				continue
+			}
+			if field.Anonymous && name == "" {
+				t.flatten(row, prefix, v.Field(i))
+				continue
+			}
+			if name == "" {
+				name = strings.ToLower(field.Name)
+			}
+			if renamed, ok := columnNames[name]; ok {
+				name = renamed
+			}
+			t.flatten(row, join(name), v.Field(i))
+		}
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return lessKey(keys[i], keys[j]) })
+		for _, key := range keys {
+			name := join(fmt.Sprint(key))
+			t.mapColumns[name] = mapColumn{prefix: prefix, key: key}
+			t.flatten(row, name, v.MapIndex(key))
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			t.flatten(row, join(strconv.Itoa(i)), v.Index(i))
+		}
+	case reflect.Float32, reflect.Float64:
+		t.add(row, prefix, v.Float(), reflect.Float64)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		t.add(row, prefix, v.Int(), reflect.Int64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		t.add(row, prefix, int64(v.Uint()), reflect.Int64)
+	case reflect.Bool:
+		t.add(row, prefix, v.Bool(), reflect.Bool)
+	case reflect.String:
+		t.add(row, prefix, v.String(), reflect.String)
+	}
+}
+
+//sortMapColumns moves the columns of every map next to the first one registered and sorts them by key. Keys first
+//seen in a later row, such as a reorg depth only reached at a higher alpha, would otherwise come after every column
+//of the first row.
+func (t *resultsTable) sortMapColumns() {
+	var columns []string
+	sorted := make(map[string]bool)
+	for _, column := range t.columns {
+		m, ok := t.mapColumns[column]
+		if !ok {
+			columns = append(columns, column)
+			continue
+		}
+		if sorted[m.prefix] {
+			continue
+		}
+		sorted[m.prefix] = true
+		var group []string
+		for _, c := range t.columns {
+			if other, ok := t.mapColumns[c]; ok && other.prefix == m.prefix {
+				group = append(group, c)
+			}
+		}
+		sort.SliceStable(group, func(i, j int) bool { return lessKey(t.mapColumns[group[i]].key, t.mapColumns[group[j]].key) })
+		columns = append(columns, group...)
+	}
+	t.columns = columns
+}
+
+//newResultsTable flattens every parameter point of the results, single attacker, multi-miner and two-chain alike.
+//Every row starts with the algo, the strategies and the difficulty parameters, prefixed by param_.
+func newResultsTable(all AllResults) *resultsTable {
+	t := &resultsTable{kinds: make(map[string]reflect.Kind), mapColumns: make(map[string]mapColumn)}
+	addRow := func(mode string, point interface{}) {
+		row := make(map[string]interface{})
+		t.add(row, "daa", all.Daa, reflect.String)
+		t.add(row, "mode", mode, reflect.String)
+		t.add(row, "strategy", all.Strategy, reflect.String)
+		t.add(row, "timestamps", all.Timestamps, reflect.String)
+		t.flatten(row, "param", reflect.ValueOf(all.Params))
+		t.flatten(row, "", reflect.ValueOf(point))
+		t.rows = append(t.rows, row)
+	}
+	for _, r := range all.Results {
+		addRow("single", r)
+	}
+	for _, r := range all.MultiResults {
+		addRow("multi", r)
+	}
+	for _, r := range all.TwoChainResults {
+		addRow("twochain", r)
+	}
+	t.sortMapColumns()
+	return t
+}
+
+//exportFileName returns a new file name for the results of this run next to the results file, so exports never
+//overwrite each other.
+func exportFileName(daa, format string) string {
+	base := filepath.Join(filepath.Dir(resultFileName), fmt.Sprintf("results_%s_%s", daa, time.Now().Format("20060102-150405")))
+	fileName := base + "." + format
+	for i := 2; ; i++ {
+		if _, err := os.Stat(fileName); os.IsNotExist(err) {
+			return fileName
+		}
+		fileName = fmt.Sprintf("%s_%d.%s", base, i, format)
+	}
+}
+
+//writeCSV writes the table with a header row, leaving missing values empty.
+func (t *resultsTable) writeCSV(fileName string) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write(t.columns)
+	for _, row := range t.rows {
+		record := make([]string, len(t.columns))
+		for i, column := range t.columns {
+			switch v := row[column].(type) {
+			case float64:
+				record[i] = strconv.FormatFloat(v, 'g', -1, 64)
+			case nil:
+			default:
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+//writeParquet writes the table with one optional column per table column, in the same order. The schema is built
+//from a struct type made at run time, since the columns depend on the algo and the simulation mode.
+func (t *resultsTable) writeParquet(fileName string) error {
+	types := map[reflect.Kind]reflect.Type{
+		reflect.Float64: reflect.TypeOf(float64(0)),
+		reflect.Int64:   reflect.TypeOf(int64(0)),
+		reflect.Bool:    reflect.TypeOf(false),
+		reflect.String:  reflect.TypeOf(""),
+	}
+	fields := make([]reflect.StructField, len(t.columns))
+	for i, column := range t.columns {
+		fields[i] = reflect.StructField{
+			Name: fmt.Sprintf("C%d", i),
+			Type: reflect.PtrTo(types[t.kinds[column]]),
+			Tag:  reflect.StructTag(fmt.Sprintf(`parquet:"%s,optional"`, column)),
+		}
+	}
+	rowType := reflect.StructOf(fields)
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	w := parquet.NewWriter(f, parquet.SchemaOf(reflect.New(rowType).Interface()))
+	for _, row := range t.rows {
+		record := reflect.New(rowType)
+		for i, column := range t.columns {
+			if v, ok := row[column]; ok {
+				value := reflect.New(types[t.kinds[column]])
+				value.Elem().Set(reflect.ValueOf(v))
+				record.Elem().Field(i).Set(value)
+			}
+		}
+		if err := w.Write(record.Interface()); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+//exportResults saves the results of this run as a table in the given format.
+func exportResults(format string) error {
+	t := newResultsTable(results)
+	fileName := exportFileName(results.Daa, format)
+	fmt.Printf("Writing results to %s\n", fileName)
+	if format == "parquet" {
+		return t.writeParquet(fileName)
+	}
+	return t.writeCSV(fileName)
+}
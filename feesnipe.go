@@ -0,0 +1,90 @@
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+//FeeSnipe holds the parameters of the fee-sniping (undercutting) strategy. The attacker mines honestly
+//and publishes immediately until an honest tip holds at least Threshold times the fees a block is expected
+//to hold. It then forks the tip, mining privately on its parent, and publishes as soon as its branch ties
+//or beats the main chain. The attempt is abandoned once the main chain leads by more than GiveUp blocks.
+type FeeSnipe struct {
+	Threshold     float64 `json:"threshold"`
+	GiveUp        int     `json:"giveup"`
+	Undercut      float64 `json:"undercut"`      //Fraction of the available fees the sniping blocks leave for the next miner
+	UndercutGamma float64 `json:"undercutgamma"` //Fraction of honest miners mining on an undercutting branch during a race
+}
+
+//shouldSnipe reports whether the tip of the main chain is worth forking.
+func (sim *Simulation) shouldSnipe() bool {
+	tip := sim.blockchain.chain[sim.blockchain.height]
+	if !tip.isHonest || tip.height <= sim.blockchain.startingBlocks {
+		return false
+	}
+	return tip.fees >= sim.feeSnipe.Threshold*sim.blockchain.rewards.expectedFees(sim.expectedBlockTime)
+}
+
+//startSnipe forks the tip of the main chain, the private branch will be mined on the tip's parent.
+func (sim *Simulation) startSnipe() {
+	log.WithField("Fees", sim.blockchain.chain[sim.blockchain.height].fees).Debug("startSnipe")
+	sim.sniping = true
+	sim.numSnipes++
+	sim.blockchain.privateUndercut = sim.feeSnipe.Undercut
+	sim.blockchain.forkAt(sim.blockchain.height - 1)
+}
+
+//stopSnipe abandons the private branch and goes back to mining on the tip.
+func (sim *Simulation) stopSnipe() {
+	log.Debug("stopSnipe")
+	sim.sniping = false
+	sim.blockchain.clearPrivateBrach()
+	sim.blockchain.setForkHeight(0)
+	sim.blockchain.nextPrivateDifficulty = sim.blockchain.nextDifficulty
+}
+
+//feeSnipeStep simulates the next block while the attacker is either mining honestly or sniping a fork.
+func (sim *Simulation) feeSnipeStep() {
+	if !sim.sniping {
+		isHonest := !sim.nextCommonBlock()
+
+		//The attacker publishes immediately, with an honest timestamp
+		sim.resolveHonestFork(isHonest)
+		sim.blockchain.newPublicBlock(sim.realTime, isHonest)
+		if isHonest {
+			sim.maybeHonestFork()
+		}
+		if sim.honestFork == nil && sim.shouldSnipe() {
+			sim.startSnipe()
+		}
+		return
+	}
+
+	delayHonest, delaySelfish := sim.getDelays()
+	if delaySelfish < delayHonest {
+		sim.setRealTime(delaySelfish)
+		sim.newPrivateBlock()
+		mainWork, privWork := sim.postForkWork()
+		if privWork > mainWork {
+			sim.blockchain.reorg()
+			sim.sniping = false
+			sim.successfulSnipes++
+			sim.setState(0)
+		} else if privWork == mainWork {
+			//Publish and race, the race is resolved like a selfish mining race
+			sim.sniping = false
+			sim.snipeRace = true
+			sim.setState(-1)
+		}
+		return
+	}
+
+	sim.setRealTime(delayHonest)
+	sim.blockchain.newBlock(sim.realTime)
+	mainLen := sim.blockchain.height - sim.blockchain.forkHeight
+	if mainLen-len(sim.blockchain.privateBranch) > sim.feeSnipe.GiveUp {
+		sim.stopSnipe()
+		if sim.shouldSnipe() {
+			sim.startSnipe()
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//HashrateSchedule gives a hashrate multiplier for every second since the start of the simulated blocks.
+//A multiplier of 1 is the hashrate the chain starts with. Schedules are written as
+//	const			no change
+//	exp:rate		exponential growth, rate per day (negative for a decline)
+//	steps:t1=m1,t2=m2	step shocks, multiplier m1 from second t1 on, m2 from t2 on... (0 to stop mining)
+//	piecewise:t0=m0,t1=m1	linear interpolation between points, constant outside of them
+//	csv:file.csv		piecewise from a CSV file of seconds,multiplier rows
+type HashrateSchedule struct {
+	Spec   string    `json:"spec"`
+	kind   string    //const, exp, steps or piecewise
+	rate   float64   //Growth per day for exp
+	times  []float64 //Points for steps and piecewise
+	values []float64
+}
+
+const secondsPerDay = 86400.0
+
+//parseHashrateSchedule parses a hashrate schedule spec.
+func parseHashrateSchedule(spec string) (HashrateSchedule, error) {
+	s := HashrateSchedule{Spec: spec, kind: "const"}
+	parts := strings.SplitN(strings.TrimSpace(spec), ":", 2)
+	switch strings.ToLower(parts[0]) {
+	case "", "const":
+		return s, nil
+	case "exp":
+		if len(parts) != 2 {
+			return s, fmt.Errorf("missing growth rate in %q", spec)
+		}
+		rate, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return s, fmt.Errorf("invalid growth rate in %q", spec)
+		}
+		s.kind, s.rate = "exp", rate
+		return s, nil
+	case "steps", "piecewise":
+		if len(parts) != 2 {
+			return s, fmt.Errorf("missing points in %q", spec)
+		}
+		s.kind = strings.ToLower(parts[0])
+		for _, point := range strings.Split(parts[1], ",") {
+			tv := strings.Split(point, "=")
+			if len(tv) != 2 {
+				return s, fmt.Errorf("invalid point %q in %q", point, spec)
+			}
+			if err := s.addPoint(tv[0], tv[1]); err != nil {
+				return s, err
+			}
+		}
+	case "csv":
+		if len(parts) != 2 {
+			return s, fmt.Errorf("missing file in %q", spec)
+		}
+		s.kind = "piecewise"
+		if err := s.loadCSV(parts[1]); err != nil {
+			return s, err
+		}
+	default:
+		return s, fmt.Errorf("invalid hashrate schedule %q", spec)
+	}
+
+	if len(s.times) == 0 {
+		return s, fmt.Errorf("no points in %q", spec)
+	}
+	if !sort.Float64sAreSorted(s.times) {
+		return s, fmt.Errorf("points are not in increasing time in %q", spec)
+	}
+	return s, nil
+}
+
+func (s *HashrateSchedule) addPoint(t, v string) error {
+	time, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+	if err != nil {
+		return fmt.Errorf("invalid time %q", t)
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil || value < 0 {
+		return fmt.Errorf("invalid hashrate multiplier %q", v)
+	}
+	s.times = append(s.times, time)
+	s.values = append(s.values, value)
+	return nil
+}
+
+//loadCSV reads seconds,multiplier rows, skipping a header if there is one.
+func (s *HashrateSchedule) loadCSV(fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return err
+	}
+	for i, row := range rows {
+		if len(row) < 2 {
+			return fmt.Errorf("row %d of %s needs a time and a multiplier", i+1, fileName)
+		}
+		if err := s.addPoint(row[0], row[1]); err != nil {
+			if i == 0 {
+				continue //Header
+			}
+			return fmt.Errorf("row %d of %s: %v", i+1, fileName, err)
+		}
+	}
+	return nil
+}
+
+//at returns the hashrate multiplier the given number of seconds after the start of the simulated blocks.
+func (s HashrateSchedule) at(seconds int) float64 {
+	return s.multiplier(float64(seconds))
+}
+
+//multiplier returns the hashrate multiplier t seconds after the start of the simulated blocks.
+func (s HashrateSchedule) multiplier(t float64) float64 {
+	switch s.kind {
+	case "exp":
+		return math.Exp(s.rate * t / secondsPerDay)
+	case "steps":
+		multiplier := 1.0
+		for i, time := range s.times {
+			if t >= time {
+				multiplier = s.values[i]
+			}
+		}
+		return multiplier
+	case "piecewise":
+		i := sort.SearchFloat64s(s.times, t)
+		if i == 0 {
+			return s.values[0]
+		}
+		if i == len(s.times) {
+			return s.values[len(s.values)-1]
+		}
+		frac := (t - s.times[i-1]) / (s.times[i] - s.times[i-1])
+		return s.values[i-1] + frac*(s.values[i]-s.values[i-1])
+	}
+	return 1.0
+}
+
+//delay returns the seconds after elapsed until a miner following the schedule, finding rate blocks per second at a
+//multiplier of 1, has done work expected blocks of work. With work drawn from Exp(1) this is the delay to its next
+//block: the rate is integrated over the schedule, so a shock before the block is found changes when it is found.
+//It returns +Inf if the work is never done.
+func (s HashrateSchedule) delay(elapsed, rate, work float64) float64 {
+	if rate <= 0 {
+		return math.Inf(1)
+	}
+	work /= rate //Seconds at a multiplier of 1
+	switch s.kind {
+	case "exp":
+		if s.rate == 0 {
+			return work
+		}
+		//The integral of e^(k t) from elapsed to elapsed+x is (e^(k (elapsed+x)) - e^(k elapsed)) / k
+		k := s.rate / secondsPerDay
+		end := math.Exp(k*elapsed) + k*work
+		if end <= 0 {
+			return math.Inf(1)
+		}
+		return math.Log(end)/k - elapsed
+	case "steps", "piecewise":
+		//Between points the multiplier is constant (steps) or linear (piecewise), walk the segments until the work is done
+		t := elapsed
+		for {
+			next := math.Inf(1)
+			for _, time := range s.times {
+				if time > t {
+					next = time
+					break
+				}
+			}
+			m := s.multiplier(t)
+			if math.IsInf(next, 1) {
+				if m <= 0 {
+					return math.Inf(1)
+				}
+				return t + work/m - elapsed
+			}
+			slope := 0.0
+			if s.kind == "piecewise" && t >= s.times[0] {
+				slope = (s.multiplier(next) - m) / (next - t)
+			}
+			span := next - t
+			if done := m*span + slope*span*span/2; done < work || done <= 0 {
+				work -= done
+				t = next
+				continue
+			}
+			if slope == 0 {
+				return t + work/m - elapsed
+			}
+			return t + (math.Sqrt(math.Max(0, m*m+2*slope*work))-m)/slope - elapsed
+		}
+	}
+	return work
+}
+
+//isConst reports whether the schedule never changes the hashrate.
+func (s HashrateSchedule) isConst() bool {
+	return s.kind == "" || s.kind == "const"
+}
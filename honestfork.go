@@ -0,0 +1,48 @@
+package main
+
+import (
+	"math"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/rand"
+	distuv "gonum.org/v1/gonum/stat/distuv"
+)
+
+//maybeHonestFork is called after an honest block is found while there is no private branch. If another honest
+//miner finds a block before the new one has propagated to it, the two blocks compete at the same height.
+func (sim *Simulation) maybeHonestFork() {
+	if sim.propagationDelay == nil {
+		return
+	}
+	delay := math.Max(0, sim.propagationDelay.Rand())
+	lambdaHonest, _ := sim.getLambdas()
+	competing := distuv.Exponential{
+		Rate: lambdaHonest,
+		Src:  randsrc,
+	}.Rand()
+	if competing >= delay {
+		return
+	}
+	block := sim.blockchain.newSiblingBlock(sim.realTime + int(competing))
+	sim.honestFork = &block
+	//Both blocks reach every honest miner uniformly within the delay, the sibling competing seconds later, so
+	//P(sibling first) = (1 - competing/delay)^2 / 2: an even split for simultaneous blocks, none as competing nears delay
+	sim.honestForkShare = math.Pow(1-competing/delay, 2) / 2
+	sim.naturalForks++
+	log.WithField("Height", block.height).Debug("Natural honest fork")
+}
+
+//resolveHonestFork settles a natural honest fork once the next block is found. Honest miners are split between the
+//two blocks by which one reached them first, while the selfish miner mines on the block it saw first, the main chain tip.
+func (sim *Simulation) resolveHonestFork(isHonestNext bool) {
+	if sim.honestFork == nil {
+		return
+	}
+	if isHonestNext && rand.Float64() < sim.honestForkShare {
+		sim.blockchain.switchTip(*sim.honestFork)
+	} else {
+		sim.blockchain.orphan(*sim.honestFork)
+	}
+	sim.naturalStales++
+	sim.honestFork = nil
+}
@@ -18,68 +18,114 @@ import (
 	yaml "gopkg.in/yaml.v2"
 )
 
-var algoMap = map[string]Difficulty{
-	"btc":  btcDifficulty{Period: 2016, OffByOne: true},
-	"bch":  bchDifficulty{Lookback: 144, OffByOne: true, Mediantimepast: 3},
-	"dash": dashDifficulty{NPastBlocks: 24, OffByOne: true},
-	"xmr":  xmrDifficulty{Lookback: 720, Delay: 15, Outliers: 60},
-	"zec": zecDifficulty{NAveragingInterval: 17, NMedianTimespan: 11, NMaxAdjustUp: 16,
-		NMaxAdjustDown: 32, NPOWDampeningFactor: 4.0},
-}
-
 //SimulationAvgResults contains the average reults for numsims runs of the simulation for the given params
 type SimulationAvgResults struct {
-	NumSims                int     `json:"numsims"`
-	Alpha                  float64 `json:"alpha"`
-	Gamma                  float64 `json:"gamma"`
-	Timewarp               int     `json:"timewarp"`
-	Numblocks              int     `json:"numblocks"`
-	Blocktime              int     `json:"blocktime"`
-	WinRatio               float64 `json:"winratio"`
-	AdjustedWinning        float64 `json:"adjustedwinning"`
-	SelfishSecondsPerBlock float64 `json:"selfishsecondsperblock"`
-	RelativeGain           float64 `json:"relativegain"`
-	AdjustedRelativeGain   float64 `json:"adjustedrelativegain"`
-	GainStdDev             float64 `json:"gainstddev"`
-	AdjustedGainStdDev     float64 `json:"adjustedgainsteddev"`
-	SecondsPerBlockStdDev  float64 `json:"secondsperblockstddev"`
-	FinalHeight            float64 `json:"finalheight"`
-	NumReorgs              float64 `json:"numreorgs"`
-	SmWinReorgs            float64 `json:"smwinreorgs"`
-	DidBetterNaive         float64 `json:"didbetternaive"`
-	DidBetterTimeAdjust    float64 `json:"didbettertimeadjust"`
+	NumSims                int             `json:"numsims"`
+	Alpha                  float64         `json:"alpha"`
+	Gamma                  float64         `json:"gamma"`
+	Timewarp               int             `json:"timewarp"`
+	Numblocks              int             `json:"numblocks"`
+	Blocktime              int             `json:"blocktime"`
+	WinRatio               float64         `json:"winratio"`
+	AdjustedWinning        float64         `json:"adjustedwinning"`
+	SelfishSecondsPerBlock float64         `json:"selfishsecondsperblock"`
+	RelativeGain           float64         `json:"relativegain"`
+	AdjustedRelativeGain   float64         `json:"adjustedrelativegain"`
+	GainStdDev             float64         `json:"gainstddev"`
+	AdjustedGainStdDev     float64         `json:"adjustedgainsteddev"`
+	SecondsPerBlockStdDev  float64         `json:"secondsperblockstddev"`
+	FinalHeight            float64         `json:"finalheight"`
+	NumReorgs              float64         `json:"numreorgs"`
+	SmWinReorgs            float64         `json:"smwinreorgs"`
+	DidBetterNaive         float64         `json:"didbetternaive"`
+	DidBetterTimeAdjust    float64         `json:"didbettertimeadjust"`
+	HonestOrphanRate       float64         `json:"honestorphanrate"`
+	SelfishOrphanRate      float64         `json:"selfishorphanrate"`
+	WastedHonestWork       float64         `json:"wastedhonestwork"`
+	ReorgDepths            map[int]float64 `json:"reorgdepths"` //Average number of reorgs per simulation by depth
+	SelfishRevenue         float64         `json:"selfishrevenue"`
+	HonestRevenue          float64         `json:"honestrevenue"`
+	RevenueShare           float64         `json:"revenueshare"`
+	RevenueRatio           float64         `json:"revenueratio"`
+	RevenueRelativeGain    float64         `json:"revenuerelativegain"`
+	NumSnipes              float64         `json:"numsnipes"`
+	SuccessfulSnipes       float64         `json:"successfulsnipes"`
+	UncleRate              float64         `json:"unclerate"`
+	SimulatedGamma         float64         `json:"simulatedgamma"`
+	EffectiveAlpha         float64         `json:"effectivealpha"`
+	NaturalForks           float64         `json:"naturalforks"`
+	HonestStaleRate        float64         `json:"honeststalerate"`
+	OutOfOrderRate         float64         `json:"outoforderrate"`
+	ChainDistortion
+
+	TimestampAttack *TimestampAttackResults `json:"timestampattack,omitempty"` //Only with the timestamp strategy
+	Phases          []PhaseResult           `json:"phases,omitempty"`
 }
 
 //AllResults encompases all results for this program execution
 type AllResults struct {
-	Daa     string                 `json:"daa"`
-	Params  Difficulty             `json:"difficulty_parameters"`
-	Results []SimulationAvgResults `json:"results"`
+	Daa              string                 `json:"daa"`
+	Params           Difficulty             `json:"difficulty_parameters"`
+	Rewards          RewardModel            `json:"reward_parameters"`
+	Strategy         string                 `json:"strategy"`
+	Timestamps       string                 `json:"timestamps"`
+	FeeSnipe         *FeeSnipe              `json:"feesnipe_parameters,omitempty"`
+	ForkChoice       string                 `json:"forkchoice"`
+	Results          []SimulationAvgResults `json:"results"`
+	MultiResults     []MultiAvgResults      `json:"multi_results,omitempty"`
+	TwoChain         *TwoChainConfig        `json:"twochain,omitempty"`
+	TwoChainResults  []TwoChainAvgResults   `json:"twochain_results,omitempty"`
+	Network          *Network               `json:"network,omitempty"`
+	PropagationDelay *Distribution          `json:"propagationdelay,omitempty"`
+	WarmUp           *WarmUp                `json:"warmup,omitempty"`
+	Clock            *ClockModel            `json:"clock,omitempty"`
+	Phases           *Phases                `json:"phases,omitempty"`
+	HonestHashrate   *HashrateSchedule      `json:"honesthashrate,omitempty"`
+	SelfishHashrate  *HashrateSchedule      `json:"selfishhashrate,omitempty"`
 }
 
 var results AllResults
 var resultFile *os.File
 var appending bool
 var resultFileName = "results.json"
+var outputFormat = "json"
 
 func main() {
 
-	if _, err := os.Stat(resultFileName); err == nil {
-		appending = true
-	} else {
-		appending = false
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "plot":
+			if err := runPlot(os.Args[2:]); err != nil {
+				log.WithField("Error", err).Fatal("Failed to plot results")
+			}
+			return
+		case "compare":
+			if err := runCompare(os.Args[2:]); err != nil {
+				log.WithField("Error", err).Fatal("Failed to compare results")
+			}
+			return
+		case "serve":
+			if err := runServe(os.Args[2:]); err != nil {
+				log.WithField("Error", err).Fatal("Failed to serve jobs")
+			}
+			return
+		}
 	}
-	//resultFile, err = os.OpenFile(resultFileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
 
 	var numSims, numBlocks, timewarp, blockTime int
 	var alpha, gamma float64
-	var daa, logLevel string
+	var daa, logLevel, strategy, forkChoice, minersFile, networkFile, propagationDelay string
+	var honestHashrate, selfishHashrate, twoChainFile string
+	var validateFile, validateOut string
+	var validateSkip, warmUpBlocks, clockMiners int
+	var warmUpMethod, clockOffset, clockJitter, timestamps, phases string
+	var feeSnipe FeeSnipe
 
 	//Variables if we are adjusting parameters across different simulations
 	var timewarpMax, timewarpStep int
 	var alphaMax, alphaStep, gammaMax, gammaStep float64
 
-	flag.StringVar(&daa, "algo", "", "REQUIRED Difficulty algorithm to use. Options: BTC, BCH, ZEC, XMR, DASH")
+	flag.StringVar(&daa, "algo", "", "REQUIRED Difficulty algorithm to use. Options: "+strings.Join(difficultyNames(), ", ")+", or any name.yaml with a formula section")
 
 	flag.IntVar(&numSims, "numsims", 1, "Number of simulations to run. If we are over a range, this is the number of sims per permutation of parameters.")
 	flag.IntVar(&numBlocks, "numblocks", 5000, "Number of blocks to simulate per simulation")
@@ -96,13 +142,77 @@ func main() {
 	flag.IntVar(&timewarpMax, "timewarpmax", 0, "Max timewarp if we are iterating over a range")
 	flag.IntVar(&timewarpStep, "timewarpstep", 1, "How much to increment timewarp per iteration")
 
+	flag.StringVar(&strategy, "strategy", "selfish", "Attacker strategy. Options: selfish, feesnipe, timestamp (publish immediately, applying -timestamps)")
+	flag.Float64Var(&feeSnipe.Threshold, "snipethreshold", 3.0, "Fee-sniping forks a tip holding at least this many times the expected fees per block")
+	flag.IntVar(&feeSnipe.GiveUp, "snipegiveup", 1, "Fee-sniping abandons a fork once the main chain leads by more than this many blocks")
+	flag.Float64Var(&feeSnipe.Undercut, "undercut", 0.0, "Fraction of the available fees fee-sniping blocks leave for the next miner")
+	flag.Float64Var(&feeSnipe.UndercutGamma, "undercutgamma", 0.0, "Fraction of honest miners that mine on an undercutting block during a race (if above gamma)")
+
+	flag.StringVar(&minersFile, "miners", "", "YAML file listing miners (name, share, strategy, timewarp) to simulate several competing miners. Alpha and timewarp flags are then ignored")
+	flag.StringVar(&networkFile, "network", "", "YAML file describing the honest nodes and block propagation. Gamma is then derived from the network for every race")
+	flag.StringVar(&propagationDelay, "propdelay", "", "Distribution of the seconds an honest block takes to reach the other honest miners, e.g. exp:2. Defaults to the network latency if a network is given, else instant")
+	flag.StringVar(&honestHashrate, "honesthashrate", "const", "Honest hashrate over time as a multiple of its starting value. Options: const, exp:rate (per day), steps:t1=m1,t2=m2 (seconds), piecewise:t0=m0,t1=m1, csv:file")
+	flag.StringVar(&selfishHashrate, "selfishhashrate", "const", "Selfish hashrate over time as a multiple of its starting value, same options as -honesthashrate")
+	flag.StringVar(&twoChainFile, "twochain", "", "YAML file describing two chains sharing a PoW algorithm and a profit-switching pool mining either. Algo, alpha and timewarp flags are then ignored")
+	flag.IntVar(&warmUpBlocks, "warmup", 0, "Number of starting blocks before the simulated ones. 0 for 3000 generated blocks or the whole imported history")
+	flag.StringVar(&warmUpMethod, "warmupmethod", "constant", "How the starting blocks are generated. Options: constant (perfect spacing), poisson (random solvetimes), history:file (CSV or JSON headers)")
+	flag.StringVar(&phases, "phases", "", "Blocks the attacker is honest for, attacks for, then is honest again for, e.g. 1000,2000,3000. Overrides numblocks")
+	flag.StringVar(&timestamps, "timestamps", "offset", "How the attacker stamps its blocks, T being the timewarp. Options: offset (real time + T), alternate (+T and -T), mtp (median time past + 1), reverse (T before the parent), file:name (one offset per line)")
+	flag.StringVar(&clockOffset, "clockoffset", "const:0", "Distribution of the seconds each honest miner's clock is ahead of the real time, e.g. normal:0:30. Honest timestamps are the real time unless this or -clockjitter is set")
+	flag.StringVar(&clockJitter, "clockjitter", "const:0", "Distribution of the seconds added to every honest timestamp, e.g. uniform:-10:10")
+	flag.IntVar(&clockMiners, "clockminers", 10, "Number of honest miners with their own clock offset")
+	flag.StringVar(&forkChoice, "forkchoice", "heaviest", "Fork choice rule. Options: heaviest (heaviest chain), ghost (heaviest subtree)")
+
+	flag.StringVar(&validateFile, "validate", "", "CSV file of historical headers (height, timestamp, difficulty or bits) to validate the algo against instead of simulating")
+	flag.IntVar(&validateSkip, "validateskip", 0, "Number of validated blocks left out of the error statistics, e.g. while the algo window reaches before the first header")
+	flag.StringVar(&validateOut, "validateout", "", "CSV file to write the per-block validation results to")
+
+	flag.StringVar(&resultFileName, "results", resultFileName, "JSON file to append the results to. CSV and Parquet exports are written to its directory")
+	flag.StringVar(&outputFormat, "output-format", "json", "Format to save the results in. Options: json (appended to results.json), csv, parquet (one row per parameter point, in a new results_algo_time file)")
+
 	flag.StringVar(&logLevel, "loglevel", "warn", "Logging level. Options: Debug, Info, Warn, Error. If invalid given, fallback to warn")
 
 	flag.Parse()
 
+	if _, err := os.Stat(resultFileName); err == nil {
+		appending = true
+	} else {
+		appending = false
+	}
+	//resultFile, err = os.OpenFile(resultFileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+
+	outputFormat = strings.ToLower(outputFormat)
+	if outputFormat != "json" && outputFormat != "csv" && outputFormat != "parquet" {
+		flag.Usage()
+		log.Fatal("Attempted to use invalid output format")
+	}
+
+	if warmUpBlocks < 0 {
+		flag.Usage()
+		log.Fatal("Attempted to use invalid number of starting blocks")
+	}
+	if warmUpBlocks != 0 || strings.ToLower(warmUpMethod) != "constant" {
+		w, err := parseWarmUp(warmUpMethod, warmUpBlocks)
+		if err != nil {
+			flag.Usage()
+			log.WithField("Error", err).Fatal("Attempted to use invalid warm-up")
+		}
+		warmUp = w
+		results.WarmUp = &warmUp
+	}
+
+	if twoChainFile != "" {
+		var err error
+		if results.TwoChain, err = loadTwoChainConfig(twoChainFile); err != nil {
+			flag.Usage()
+			log.WithField("Error", err).Fatal("Attempted to use invalid two-chain file")
+		}
+		daa = results.TwoChain.Chains[0].Algo
+	}
+
 	daa = strings.ToLower(daa)
 
-	if _, ok := algoMap[daa]; !ok {
+	if _, ok := lookupDifficulty(daa); !ok {
 		flag.Usage()
 		log.Fatal("Attempted to use invalid diff algo")
 	}
@@ -117,22 +227,119 @@ func main() {
 		log.Fatal("Attempted to use invalid iteration parameters for alpha or gamma")
 	}
 
+	if validateSkip < 0 {
+		flag.Usage()
+		log.Fatal("Attempted to use invalid validation parameters")
+	}
+
 	if timewarpMax < 0 || timewarpMax > 7200 || (timewarpMax > 0 && timewarpMax <= timewarp) {
 		flag.Usage()
 		log.Fatal("Attempted to use invalid iteration parameters for timewarp")
 	}
 
-	if blockTime == -1 {
-		switch daa {
-		case "btc", "bch":
-			blockTime = 600
-		case "dash", "zec":
-			blockTime = 150
-		case "xmr":
-			blockTime = 120
+	strategy = strings.ToLower(strategy)
+	if strategy != "selfish" && strategy != "feesnipe" && strategy != "timestamp" {
+		flag.Usage()
+		log.Fatal("Attempted to use invalid strategy")
+	}
+
+	var minerConfigs []MinerConfig
+	if minersFile != "" {
+		var err error
+		if minerConfigs, err = loadMinerConfigs(minersFile); err != nil {
+			flag.Usage()
+			log.WithField("Error", err).Fatal("Attempted to use invalid miners file")
+		}
+	}
+
+	if networkFile != "" {
+		var err error
+		if results.Network, err = loadNetwork(networkFile); err != nil {
+			flag.Usage()
+			log.WithField("Error", err).Fatal("Attempted to use invalid network file")
 		}
 	}
 
+	if propagationDelay != "" {
+		delay, err := parseDistribution(propagationDelay)
+		if err != nil {
+			flag.Usage()
+			log.WithField("Error", err).Fatal("Attempted to use invalid propagation delay")
+		}
+		results.PropagationDelay = &delay
+	} else if results.Network != nil {
+		results.PropagationDelay = &results.Network.Latency
+	}
+
+	if phases != "" {
+		var err error
+		if results.Phases, err = parsePhases(phases); err != nil {
+			flag.Usage()
+			log.WithField("Error", err).Fatal("Attempted to use invalid phases")
+		}
+		if minersFile != "" || twoChainFile != "" {
+			flag.Usage()
+			log.Fatal("Phases are not supported with several miners or two chains")
+		}
+		numBlocks = results.Phases.total()
+	}
+
+	timestampStrategy, err := parseTimestampStrategy(timestamps)
+	if err != nil {
+		flag.Usage()
+		log.WithField("Error", err).Fatal("Attempted to use invalid timestamp strategy")
+	}
+	attackerTimestamps = timestampStrategy
+	results.Timestamps = attackerTimestamps.Spec
+
+	if clockOffset != "const:0" || clockJitter != "const:0" {
+		clock := ClockModel{Miners: clockMiners}
+		if clockMiners < 1 {
+			flag.Usage()
+			log.Fatal("Attempted to use invalid number of honest clocks")
+		}
+		if clock.Offset, err = parseDistribution(clockOffset); err != nil {
+			flag.Usage()
+			log.WithField("Error", err).Fatal("Attempted to use invalid clock offset")
+		}
+		if clock.Jitter, err = parseDistribution(clockJitter); err != nil {
+			flag.Usage()
+			log.WithField("Error", err).Fatal("Attempted to use invalid clock jitter")
+		}
+		clockModel = &clock
+		results.Clock = clockModel
+	}
+
+	honestSchedule, err := parseHashrateSchedule(honestHashrate)
+	if err != nil {
+		flag.Usage()
+		log.WithField("Error", err).Fatal("Attempted to use invalid honest hashrate schedule")
+	}
+	selfishSchedule, err := parseHashrateSchedule(selfishHashrate)
+	if err != nil {
+		flag.Usage()
+		log.WithField("Error", err).Fatal("Attempted to use invalid selfish hashrate schedule")
+	}
+	if !honestSchedule.isConst() || !selfishSchedule.isConst() {
+		results.HonestHashrate = &honestSchedule
+		results.SelfishHashrate = &selfishSchedule
+	}
+
+	forkChoice = strings.ToLower(forkChoice)
+	if forkChoice != "heaviest" && forkChoice != "ghost" {
+		flag.Usage()
+		log.Fatal("Attempted to use invalid fork choice rule")
+	}
+
+	if feeSnipe.Threshold < 0.0 || feeSnipe.GiveUp < 0 || feeSnipe.Undercut < 0.0 || feeSnipe.Undercut >= 1.0 || feeSnipe.UndercutGamma < 0.0 || feeSnipe.UndercutGamma > 1.0 {
+		flag.Usage()
+		log.Fatal("Attempted to use invalid fee-sniping parameters")
+	}
+
+	if blockTime == -1 {
+		blockTime = defaultBlockTime(daa)
+	}
+
 	if alphaMax == 0.0 {
 		alphaMax = alpha
 	}
@@ -155,9 +362,28 @@ func main() {
 	}
 
 	diffAlgo := loadYamlFile(daa)
+	if err := warmUp.checkHistory(daa, diffAlgo); err != nil {
+		flag.Usage()
+		log.WithField("Error", err).Fatal("Attempted to use invalid warm-up")
+	}
+
+	if validateFile != "" {
+		if err := runValidation(daa, diffAlgo, validateFile, blockTime, validateSkip, validateOut); err != nil {
+			log.WithField("Error", err).Fatal("Failed to validate the difficulty algorithm")
+		}
+		return
+	}
+
+	rewards := loadRewardModel(daa)
 
 	results.Daa = daa
 	results.Params = diffAlgo
+	results.Rewards = rewards
+	results.Strategy = strategy
+	results.ForkChoice = forkChoice
+	if strategy == "feesnipe" {
+		results.FeeSnipe = &feeSnipe
+	}
 
 	simuationResults := make([]SimulationResult, numSims)
 	selfishSecondsPerBlockHistory := make([]float64, numSims)
@@ -188,9 +414,26 @@ func main() {
 	fmt.Println("Simulating with the following parameters")
 	fmt.Printf("Algo: %s\tNumber of blocks: %d\tNumber of sims: %d\n", daa, numBlocks, numSims)
 	fmt.Printf("Params: %s\n", results.Params)
+	points := countSteps(gamma, gammaMax, gammaStep)
+	if results.TwoChain == nil && len(minerConfigs) == 0 {
+		points *= countSteps(alpha, alphaMax, alphaStep) * ((timewarpMax-timewarp)/timewarpStep + 1)
+	}
+	fmt.Printf("Parameter points: %d\n", points)
 	fmt.Printf("Alpha range:\t%f - %f (step: %f)\n", color.Green(alpha), color.Green(alphaMax), color.Green(alphaStep))
 	fmt.Printf("Gamma range:\t%f - %f (step: %f)\n", color.Cyan(gamma), color.Cyan(gammaMax), color.Cyan(gammaStep))
 	fmt.Printf("TImewarp range:\t%d -  %d (step: %d)\n\n", color.Magenta(timewarp), color.Magenta(timewarpMax), color.Magenta(timewarpStep))
+	if results.TwoChain != nil {
+		runTwoChainSweep(results.TwoChain, numSims, numBlocks, gamma, gammaMax, gammaStep)
+		fmt.Printf("Total running time: %s\n", time.Since(timeStart))
+		fmt.Printf("Finished at : %s ", time.Now())
+		return
+	}
+	if len(minerConfigs) > 0 {
+		runMultiMinerSweep(minerConfigs, numSims, numBlocks, gamma, gammaMax, gammaStep, diffAlgo, rewards, blockTime)
+		fmt.Printf("Total running time: %s\n", time.Since(timeStart))
+		fmt.Printf("Finished at : %s ", time.Now())
+		return
+	}
 	for alphaT := alpha; alphaT <= alphaMax; alphaT = toFixed(alphaT+alphaStep, 3) {
 		for gammaT := gamma; gammaT <= gammaMax; gammaT = toFixed(gammaT+gammaStep, 3) {
 			for timewarpT := timewarp; timewarpT <= timewarpMax; timewarpT += timewarpStep {
@@ -204,9 +447,21 @@ func main() {
 					Numblocks: numBlocks,
 					Blocktime: blockTime,
 				}
-				for i := 0; i < numSims; i++ {
+				newSim := func() *Simulation {
 					var sim Simulation
-					sim.init(alphaT, gammaT, numBlocks, timewarpT, false, diffAlgo, blockTime, rand.Int())
+					sim.init(alphaT, gammaT, numBlocks, timewarpT, false, diffAlgo, rewards, blockTime, rand.Int())
+					sim.feeSnipe = results.FeeSnipe
+					sim.isGHOST = forkChoice == "ghost"
+					sim.network = results.Network
+					sim.propagationDelay = results.PropagationDelay
+					sim.honestSchedule = honestSchedule
+					sim.selfishSchedule = selfishSchedule
+					sim.timestampAttack = strategy == "timestamp"
+					sim.phases = results.Phases
+					return &sim
+				}
+				for i := 0; i < numSims; i++ {
+					sim := newSim()
 					alpha = sim.alpha
 					go sim.runSimulation(resultChannel)
 				}
@@ -214,6 +469,13 @@ func main() {
 				var winRatioTotal, adjustedWinningTotal, selfishSecondsPerBlockTotal, numReorgsTotal, smReorgWinTotal float64
 				var relativeGainAvg, adjustedRelativeGainAvg float64
 				var didBetterNaive, didBetter float64
+				var honestOrphanTotal, selfishOrphanTotal, wastedWorkTotal float64
+				var selfishRevenueTotal, honestRevenueTotal, revenueShareTotal, revenueRatioTotal, revenueGainTotal float64
+				var numSnipesTotal, successfulSnipesTotal int
+				var uncleRateTotal, simulatedGammaTotal, honestStaleTotal float64
+				var naturalForksTotal int
+				var effectiveAlphaTotal, outOfOrderTotal float64
+				reorgDepthTotal := make(map[int]int)
 				var finalHeight int
 				for i := 0; i < numSims; i++ {
 					res := <-resultChannel
@@ -223,10 +485,10 @@ func main() {
 					gainHistory[i] = res.RelativeGain
 					adjustedGainHistory[i] = res.AdjustedRelativeGain
 
-					if res.WinRatio > alpha {
+					if res.WinRatio > res.EffectiveAlpha {
 						didBetterNaive++
 					}
-					if res.AdjustedWinning > alpha {
+					if res.AdjustedWinning > res.EffectiveAlpha {
 						didBetter++
 					}
 
@@ -236,8 +498,29 @@ func main() {
 					adjustedWinningTotal += res.AdjustedWinning
 					selfishSecondsPerBlockTotal += res.SelfishSecondsPerBlock
 					numReorgsTotal += float64(res.NumReorgs)
-					smReorgWinTotal += float64(res.SmWinReorgs) / float64(res.NumReorgs)
+					if res.NumReorgs > 0 {
+						smReorgWinTotal += float64(res.SmWinReorgs) / float64(res.NumReorgs)
+					}
 					finalHeight += res.FinalHeight
+					honestOrphanTotal += res.HonestOrphanRate
+					selfishOrphanTotal += res.SelfishOrphanRate
+					wastedWorkTotal += res.WastedHonestWork
+					selfishRevenueTotal += res.SelfishRevenue
+					honestRevenueTotal += res.HonestRevenue
+					revenueShareTotal += res.RevenueShare
+					revenueRatioTotal += res.RevenueRatio
+					revenueGainTotal += res.RevenueRelativeGain
+					numSnipesTotal += res.NumSnipes
+					successfulSnipesTotal += res.SuccessfulSnipes
+					uncleRateTotal += res.UncleRate
+					simulatedGammaTotal += res.SimulatedGamma
+					naturalForksTotal += res.NaturalForks
+					effectiveAlphaTotal += res.EffectiveAlpha
+					honestStaleTotal += res.HonestStaleRate
+					outOfOrderTotal += res.OutOfOrderRate
+					for depth, count := range res.ReorgDepths {
+						reorgDepthTotal[depth] += count
+					}
 					//fmt.Printf("Finished simulation with height %d\n", res.FinalHeight)
 				}
 
@@ -253,6 +536,35 @@ func main() {
 				avgSimResults.DidBetterNaive = didBetterNaive / float64(numSims)
 				avgSimResults.DidBetterTimeAdjust = didBetter / float64(numSims)
 
+				avgSimResults.HonestOrphanRate = honestOrphanTotal / float64(numSims)
+				avgSimResults.SelfishOrphanRate = selfishOrphanTotal / float64(numSims)
+				avgSimResults.WastedHonestWork = wastedWorkTotal / float64(numSims)
+				avgSimResults.SelfishRevenue = selfishRevenueTotal / float64(numSims)
+				avgSimResults.HonestRevenue = honestRevenueTotal / float64(numSims)
+				avgSimResults.RevenueShare = revenueShareTotal / float64(numSims)
+				avgSimResults.RevenueRatio = revenueRatioTotal / float64(numSims)
+				avgSimResults.RevenueRelativeGain = revenueGainTotal / float64(numSims)
+				avgSimResults.NumSnipes = float64(numSnipesTotal) / float64(numSims)
+				avgSimResults.SuccessfulSnipes = float64(successfulSnipesTotal) / float64(numSims)
+				avgSimResults.UncleRate = uncleRateTotal / float64(numSims)
+				avgSimResults.SimulatedGamma = simulatedGammaTotal / float64(numSims)
+				avgSimResults.EffectiveAlpha = effectiveAlphaTotal / float64(numSims)
+				avgSimResults.NaturalForks = float64(naturalForksTotal) / float64(numSims)
+				avgSimResults.HonestStaleRate = honestStaleTotal / float64(numSims)
+				avgSimResults.OutOfOrderRate = outOfOrderTotal / float64(numSims)
+				avgSimResults.ChainDistortion = averageDistortion(simuationResults)
+				if results.Phases != nil {
+					avgSimResults.Phases = averagePhases(simuationResults)
+				}
+				avgSimResults.ReorgDepths = make(map[int]float64)
+				for depth, count := range reorgDepthTotal {
+					avgSimResults.ReorgDepths[depth] = float64(count) / float64(numSims)
+				}
+
+				if strategy == "timestamp" {
+					avgSimResults.TimestampAttack = runTimestampBaseline(simuationResults, newSim, numSims)
+				}
+
 				avgSimResults.GainStdDev = calcStdDev(gainHistory)
 				avgSimResults.AdjustedGainStdDev = calcStdDev(adjustedGainHistory)
 				avgSimResults.SecondsPerBlockStdDev = calcStdDev(selfishSecondsPerBlockHistory)
@@ -268,6 +580,14 @@ func main() {
 	fmt.Printf("Finished at : %s ", time.Now())
 }
 
+//defaultBlockTime returns the target seconds between blocks of the chain using the given algo.
+func defaultBlockTime(daa string) int {
+	if def, ok := lookupDifficulty(daa); ok {
+		return def.BlockTime
+	}
+	return 600
+}
+
 func calcStdDev(inputs []float64) float64 {
 	var rounds = len(inputs)
 	var total = sum(inputs...)
@@ -284,43 +604,41 @@ func calcStdDev(inputs []float64) float64 {
 }
 
 func createYamlFiles() {
-	for algo, diff := range algoMap {
+	for algo, def := range difficultyRegistry {
 		fileName := algo + ".yaml"
 		f, _ := os.Create(fileName)
-		y, _ := yaml.Marshal(diff)
+		y, _ := yaml.Marshal(def.Params)
 		f.Write(y)
 		f.Close()
 	}
 }
 
+//loadYamlFile returns the parameters of the algo read from its YAML file, or its defaults if there is none.
 func loadYamlFile(algo string) Difficulty {
-	fileName := algo + ".yaml"
-	f, _ := os.Open(fileName)
-	d := yaml.NewDecoder(bufio.NewReader(f))
-	switch algo {
-	case "bch":
-		var temp bchDifficulty
-		d.Decode(&temp)
-		return temp
-	case "btc":
-		var temp btcDifficulty
-		d.Decode(&temp)
-		return temp
-	case "dash":
-		var temp dashDifficulty
-		d.Decode(&temp)
-		return temp
-	case "xmr":
-		var temp xmrDifficulty
-		d.Decode(&temp)
-		return temp
-	case "zec":
-		var temp zecDifficulty
-		d.Decode(&temp)
-		return temp
-	}
-
-	return nil
+	def, ok := lookupDifficulty(algo)
+	if !ok {
+		return nil
+	}
+	f, err := os.Open(algo + ".yaml")
+	if err != nil {
+		return def.Params
+	}
+	defer f.Close()
+	params, err := def.Decode(yaml.NewDecoder(bufio.NewReader(f)))
+	if err != nil {
+		log.WithFields(log.Fields{"Algo": algo, "Error": err}).Warn("Failed to decode difficulty parameters, using defaults")
+		return def.Params
+	}
+	return params
+}
+
+//countSteps returns the number of values from min to max, stepping like the simulation loops.
+func countSteps(min, max, step float64) int {
+	n := 0
+	for v := min; v <= max; v = toFixed(v+step, 3) {
+		n++
+	}
+	return n
 }
 
 func round(num float64) int {
@@ -333,6 +651,12 @@ func toFixed(num float64, precision int) float64 {
 }
 
 func saveResults() {
+	if outputFormat != "json" {
+		if err := exportResults(outputFormat); err != nil {
+			log.WithField("Error", err).Fatal("Failed to export results")
+		}
+		return
+	}
 	resultFile, err := os.OpenFile(resultFileName, os.O_CREATE|os.O_WRONLY, 0666)
 	if err != nil {
 		log.WithField("Error", err).Fatal("Failed to open results file")
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	color "github.com/logrusorgru/aurora"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/rand"
+	distuv "gonum.org/v1/gonum/stat/distuv"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//MinerConfig describes one miner of a multi-miner simulation, as read from the -miners YAML file
+type MinerConfig struct {
+	Name     string  `yaml:"name" json:"name"`
+	Share    float64 `yaml:"share" json:"share"`       //Fraction of the total hashrate
+	Strategy string  `yaml:"strategy" json:"strategy"` //honest, selfish or stubborn
+	Timewarp int     `yaml:"timewarp" json:"timewarp"` //Seconds added to the timestamps of its blocks
+}
+
+//Miner holds the state of one miner during a multi-miner simulation.
+//A miner mines on top of tip, every block between tip and the main chain is its branch.
+type Miner struct {
+	MinerConfig
+	tip    int  //id of the block the miner mines on
+	racing bool //True once the miner published a branch as long as the main chain
+}
+
+//MultiSimulation simulates any number of miners, each with its own hashrate and strategy.
+//Unlike Simulation, forks are resolved by length: a published branch longer than the main chain replaces it,
+//and when it is as long as the main chain, every honest miner on the main chain switches to it with probability gamma.
+type MultiSimulation struct {
+	blockchain        Blockchain
+	miners            []*Miner
+	gamma             float64
+	numSimBlocks      int
+	expectedBlockTime int
+	realTime          int
+	startTime         int
+	published         map[int]bool    //Blocks known to every miner, by id
+	diffCache         map[int]float64 //Difficulty of the next block on top of each tip
+	numRaces          int
+}
+
+//MinerResult holds the results of one miner for a multi-miner simulation
+type MinerResult struct {
+	WinRatio             float64 `json:"winratio"`
+	AdjustedWinning      float64 `json:"adjustedwinning"`
+	RelativeGain         float64 `json:"relativegain"`
+	AdjustedRelativeGain float64 `json:"adjustedrelativegain"`
+	RevenueShare         float64 `json:"revenueshare"`
+	OrphanRate           float64 `json:"orphanrate"`
+}
+
+//MultiSimulationResult holds the results of a multi-miner simulation
+type MultiSimulationResult struct {
+	Miners         []MinerResult `json:"miners"`
+	FinalHeight    int           `json:"finalheight"`
+	MeanDifficulty float64       `json:"meandifficulty"`
+	TimeRatio      float64       `json:"timeratio"` //Elapsed time relative to the expected time for the main chain blocks
+	NumReorgs      int           `json:"numreorgs"`
+	NumRaces       int           `json:"numraces"`
+}
+
+//MinerAvgResults contains the average results of one miner over numsims multi-miner simulations
+type MinerAvgResults struct {
+	MinerConfig
+	WinRatio             float64 `json:"winratio"`
+	AdjustedWinning      float64 `json:"adjustedwinning"`
+	RelativeGain         float64 `json:"relativegain"`
+	AdjustedRelativeGain float64 `json:"adjustedrelativegain"`
+	AdjustedGainStdDev   float64 `json:"adjustedgainstddev"`
+	RevenueShare         float64 `json:"revenueshare"`
+	OrphanRate           float64 `json:"orphanrate"`
+}
+
+//MultiAvgResults contains the average results for numsims multi-miner simulations with the given params
+type MultiAvgResults struct {
+	NumSims        int               `json:"numsims"`
+	Gamma          float64           `json:"gamma"`
+	Numblocks      int               `json:"numblocks"`
+	Blocktime      int               `json:"blocktime"`
+	Miners         []MinerAvgResults `json:"miners"`
+	MeanDifficulty float64           `json:"meandifficulty"`
+	TimeRatio      float64           `json:"timeratio"`
+	NumReorgs      float64           `json:"numreorgs"`
+	NumRaces       float64           `json:"numraces"`
+}
+
+//loadMinerConfigs reads the miners of a multi-miner simulation from a YAML file.
+func loadMinerConfigs(fileName string) ([]MinerConfig, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var configs []MinerConfig
+	if err := yaml.NewDecoder(bufio.NewReader(f)).Decode(&configs); err != nil {
+		return nil, err
+	}
+	total := 0.0
+	for i, config := range configs {
+		configs[i].Strategy = strings.ToLower(config.Strategy)
+		switch configs[i].Strategy {
+		case "honest", "selfish", "stubborn":
+		default:
+			return nil, fmt.Errorf("miner %s has invalid strategy %s", config.Name, config.Strategy)
+		}
+		if config.Share <= 0.0 {
+			return nil, fmt.Errorf("miner %s has invalid share %f", config.Name, config.Share)
+		}
+		total += config.Share
+	}
+	if math.Abs(total-1.0) > 1e-6 {
+		return nil, fmt.Errorf("miner shares add up to %f instead of 1", total)
+	}
+	return configs, nil
+}
+
+//init will initialize the simulation with the given parameters
+func (sim *MultiSimulation) init(configs []MinerConfig, gamma float64, blocks int, diffAlgo Difficulty, rewards RewardModel, expectedBlockTime int) {
+	sim.expectedBlockTime = expectedBlockTime
+	sim.blockchain.expectedBlockTime = expectedBlockTime
+	sim.blockchain.rewards = rewards
+	sim.blockchain.rewards.MaxUncles = 0 //Uncles are not modelled with multiple miners
+	sim.blockchain.Init()
+	sim.blockchain.setDiffAlgo(diffAlgo)
+	sim.numSimBlocks = blocks
+	sim.gamma = gamma
+	sim.realTime = sim.blockchain.time
+	sim.startTime = sim.blockchain.startingBlocks * expectedBlockTime
+	sim.published = make(map[int]bool)
+	for _, block := range sim.blockchain.chain {
+		sim.published[block.id] = true
+	}
+	sim.diffCache = make(map[int]float64)
+	sim.miners = nil
+	for _, config := range configs {
+		sim.miners = append(sim.miners, &Miner{MinerConfig: config, tip: sim.mainTip().id})
+	}
+}
+
+func (sim *MultiSimulation) mainTip() Block {
+	return sim.blockchain.chain[sim.blockchain.height]
+}
+
+//onMain reports whether the block is part of the main chain.
+func (sim *MultiSimulation) onMain(id int) bool {
+	block := sim.blockchain.tree[id]
+	return block.height <= sim.blockchain.height && sim.blockchain.chain[block.height].id == id
+}
+
+//branch returns the height at which the chain ending in tip forks from the main chain, and the blocks above it.
+func (sim *MultiSimulation) branch(tip int) (forkHeight int, blocks []Block) {
+	id := tip
+	for ; !sim.onMain(id); id = sim.blockchain.tree[id].parent {
+		blocks = append(blocks, sim.blockchain.tree[id])
+	}
+	for i, j := 0, len(blocks)-1; i < j; i, j = i+1, j-1 {
+		blocks[i], blocks[j] = blocks[j], blocks[i]
+	}
+	return sim.blockchain.tree[id].height, blocks
+}
+
+//difficulty returns the difficulty of the next block mined on top of tip.
+func (sim *MultiSimulation) difficulty(tip int) float64 {
+	if diff, ok := sim.diffCache[tip]; ok {
+		return diff
+	}
+	var diff float64
+	if tip == sim.mainTip().id {
+		diff = sim.blockchain.diffAlgo.getDiff(false, sim.blockchain)
+	} else {
+		forkHeight, blocks := sim.branch(tip)
+		//A copy of the blockchain keeps its warm-up length, so forks retarget on the same boundaries as the main chain
+		view := sim.blockchain
+		view.chain = append(sim.blockchain.chain[:forkHeight+1:forkHeight+1], blocks...)
+		view.height = len(view.chain) - 1
+		diff = sim.blockchain.diffAlgo.getDiff(false, view)
+	}
+	sim.diffCache[tip] = diff
+	return diff
+}
+
+//rates returns the rate at which each miner finds blocks on top of its tip, and their total.
+func (sim *MultiSimulation) rates() (rates []float64, totalRate float64) {
+	rates = make([]float64, len(sim.miners))
+	for i, miner := range sim.miners {
+		rates[i] = miner.Share / (sim.difficulty(miner.tip) * float64(sim.expectedBlockTime))
+		totalRate += rates[i]
+	}
+	return
+}
+
+//step advances the simulation to the next block, found by one of the miners.
+func (sim *MultiSimulation) step() {
+	rates, totalRate := sim.rates()
+	delay := distuv.Exponential{
+		Rate: totalRate,
+		Src:  randsrc,
+	}.Rand()
+	sim.realTime += int(delay)
+	sim.blockchain.realTime = sim.realTime
+
+	pick := rand.Float64() * totalRate
+	winner := len(sim.miners) - 1
+	for i, rate := range rates {
+		if pick < rate {
+			winner = i
+			break
+		}
+		pick -= rate
+	}
+	sim.mine(winner)
+}
+
+//mine creates a new block for the given miner on top of its tip and applies its strategy.
+func (sim *MultiSimulation) mine(index int) {
+	miner := sim.miners[index]
+	parent := sim.blockchain.tree[miner.tip]
+	fees, backlog := sim.blockchain.rewards.blockFees(parent, sim.realTime, 0)
+	var timestamp int
+	if miner.Strategy == "honest" {
+		timestamp = sim.blockchain.honestTimestamp(sim.realTime+miner.Timewarp, parent)
+	} else {
+		timestamp = attackerTimestamps.stamp(sim.realTime, miner.Timewarp, parent, &sim.blockchain)
+	}
+	block := sim.blockchain.record(Block{height: parent.height + 1, difficulty: sim.difficulty(miner.tip),
+		timestamp: timestamp, isHonest: miner.Strategy == "honest", parent: parent.id,
+		fees: fees, backlog: backlog, minedAt: sim.realTime, miner: index})
+	miner.tip = block.id
+
+	log.WithFields(log.Fields{
+		"Miner":  miner.Name,
+		"Height": block.height,
+		"Main":   sim.blockchain.height,
+	}).Info("NEW BLOCK")
+
+	switch miner.Strategy {
+	case "honest":
+		sim.publish(miner, block.height)
+	case "selfish":
+		//A selfish miner that tied the main chain publishes as soon as it gets ahead
+		if miner.racing {
+			miner.racing = false
+			sim.publish(miner, block.height)
+		}
+	}
+}
+
+//publish makes the miner's branch known up to the given height and applies the fork choice rule.
+func (sim *MultiSimulation) publish(miner *Miner, height int) {
+	candidate := sim.blockchain.tree[miner.tip]
+	for candidate.height > height {
+		candidate = sim.blockchain.tree[candidate.parent]
+	}
+	newlyPublished := false
+	for id := candidate.id; !sim.published[id]; id = sim.blockchain.tree[id].parent {
+		sim.published[id] = true
+		newlyPublished = true
+	}
+	if !newlyPublished {
+		return
+	}
+
+	tip := sim.mainTip()
+	if candidate.height > tip.height {
+		sim.reorg(candidate.id)
+		sim.onMainChange()
+	} else if candidate.height == tip.height && candidate.id != tip.id {
+		sim.numRaces++
+		for _, other := range sim.miners {
+			if other.Strategy == "honest" && other.tip == tip.id && rand.Float64() < sim.gamma {
+				other.tip = candidate.id
+			}
+		}
+	}
+}
+
+//reorg makes the branch ending in tip the main chain.
+func (sim *MultiSimulation) reorg(tip int) {
+	forkHeight, blocks := sim.branch(tip)
+	depth := sim.blockchain.height - forkHeight
+	for sim.blockchain.height > forkHeight {
+		sim.blockchain.orphan(sim.blockchain.popFromChain())
+	}
+	for _, block := range blocks {
+		sim.blockchain.pushToChain(block)
+	}
+	if depth > 0 {
+		sim.blockchain.reorgDepths = append(sim.blockchain.reorgDepths, depth)
+	}
+}
+
+//onMainChange lets every miner react to a new main chain tip. Honest miners move to the new tip first,
+//so they can all take part in a race started by a strategic miner.
+func (sim *MultiSimulation) onMainChange() {
+	for _, miner := range sim.miners {
+		if miner.Strategy == "honest" || sim.onMain(miner.tip) {
+			if tip := sim.mainTip(); sim.blockchain.tree[miner.tip].height < tip.height {
+				miner.tip = tip.id
+				miner.racing = false
+			}
+		}
+	}
+
+	for _, miner := range sim.miners {
+		if miner.Strategy == "honest" || sim.onMain(miner.tip) {
+			continue
+		}
+
+		tip := sim.mainTip()
+		lead := sim.blockchain.tree[miner.tip].height - tip.height
+		if lead < 0 { //Give up the private branch
+			miner.tip = tip.id
+			miner.racing = false
+			continue
+		}
+		switch miner.Strategy {
+		case "selfish":
+			if lead == 0 { //Race
+				miner.racing = true
+				sim.publish(miner, tip.height)
+			} else if lead == 1 { //Publish everything to orphan the new blocks
+				miner.racing = false
+				sim.publish(miner, tip.height+1)
+			} else { //Stay ahead, only match the main chain
+				sim.publish(miner, tip.height)
+			}
+		case "stubborn":
+			//Never override the main chain, only match it and keep mining on the private branch
+			sim.publish(miner, tip.height)
+		}
+	}
+}
+
+//orphanStale flags every block not on the main chain as orphaned: published or not, it was mined for nothing.
+func (sim *MultiSimulation) orphanStale() {
+	for _, block := range sim.blockchain.tree[sim.blockchain.startingBlocks:] {
+		if !sim.onMain(block.id) {
+			sim.blockchain.tree[block.id].orphaned = true
+		}
+	}
+}
+
+func (sim *MultiSimulation) runSimulation(resultChannel chan<- MultiSimulationResult) {
+	for sim.blockchain.height < sim.blockchain.startingBlocks+sim.numSimBlocks {
+		sim.step()
+	}
+
+	sim.orphanStale()
+
+	res := MultiSimulationResult{
+		Miners:      make([]MinerResult, len(sim.miners)),
+		FinalHeight: sim.blockchain.height,
+		NumReorgs:   len(sim.blockchain.reorgDepths),
+		NumRaces:    sim.numRaces,
+	}
+	numBlocks := sim.blockchain.height - sim.blockchain.startingBlocks
+	elapsedTime := sim.realTime - sim.startTime
+	res.TimeRatio = float64(elapsedTime) / float64(numBlocks*sim.expectedBlockTime)
+	res.MeanDifficulty = sumBlocks(sim.blockchain.chain[sim.blockchain.startingBlocks:]...) / float64(numBlocks)
+
+	won := make([]int, len(sim.miners))
+	for _, block := range sim.blockchain.chain[sim.blockchain.startingBlocks:] {
+		won[block.miner]++
+	}
+	mined := make([]int, len(sim.miners))
+	orphaned := make([]int, len(sim.miners))
+	for _, block := range sim.blockchain.tree[sim.blockchain.startingBlocks:] {
+		mined[block.miner]++
+		if block.orphaned {
+			orphaned[block.miner]++
+		}
+	}
+	revenue := make([]float64, len(sim.miners))
+	totalRevenue := 0.0
+	sim.blockchain.walkRewards(func(block Block, reward float64) {
+		revenue[block.miner] += reward
+		totalRevenue += reward
+	})
+
+	for i, miner := range sim.miners {
+		r := &res.Miners[i]
+		r.WinRatio = float64(won[i]) / float64(numBlocks)
+		r.AdjustedWinning = r.WinRatio / res.TimeRatio
+		r.RelativeGain = (r.WinRatio - miner.Share) / miner.Share
+		r.AdjustedRelativeGain = (r.AdjustedWinning - miner.Share) / miner.Share
+		if totalRevenue > 0 {
+			r.RevenueShare = revenue[i] / totalRevenue
+		}
+		if mined[i] > 0 {
+			r.OrphanRate = float64(orphaned[i]) / float64(mined[i])
+		}
+	}
+	resultChannel <- res
+}
+
+//runMultiMinerSweep runs numSims multi-miner simulations for every gamma in the range and appends the averages to the results.
+func runMultiMinerSweep(configs []MinerConfig, numSims, numBlocks int, gamma, gammaMax, gammaStep float64, diffAlgo Difficulty, rewards RewardModel, blockTime int) {
+	resultChannel := make(chan MultiSimulationResult, numSims)
+	for gammaT := gamma; gammaT <= gammaMax; gammaT = toFixed(gammaT+gammaStep, 3) {
+		fmt.Printf("Simulating: Miners: %d\tGamma: %f", color.Green(len(configs)), color.Cyan(gammaT))
+		simTime := time.Now()
+		for i := 0; i < numSims; i++ {
+			var sim MultiSimulation
+			sim.init(configs, gammaT, numBlocks, diffAlgo, rewards, blockTime)
+			go sim.runSimulation(resultChannel)
+		}
+
+		avgResults := MultiAvgResults{
+			NumSims:   numSims,
+			Gamma:     gammaT,
+			Numblocks: numBlocks,
+			Blocktime: blockTime,
+			Miners:    make([]MinerAvgResults, len(configs)),
+		}
+		gainHistory := make([][]float64, len(configs))
+		for i := 0; i < numSims; i++ {
+			res := <-resultChannel
+			avgResults.MeanDifficulty += res.MeanDifficulty / float64(numSims)
+			avgResults.TimeRatio += res.TimeRatio / float64(numSims)
+			avgResults.NumReorgs += float64(res.NumReorgs) / float64(numSims)
+			avgResults.NumRaces += float64(res.NumRaces) / float64(numSims)
+			for j, minerRes := range res.Miners {
+				m := &avgResults.Miners[j]
+				m.WinRatio += minerRes.WinRatio / float64(numSims)
+				m.AdjustedWinning += minerRes.AdjustedWinning / float64(numSims)
+				m.RelativeGain += minerRes.RelativeGain / float64(numSims)
+				m.AdjustedRelativeGain += minerRes.AdjustedRelativeGain / float64(numSims)
+				m.RevenueShare += minerRes.RevenueShare / float64(numSims)
+				m.OrphanRate += minerRes.OrphanRate / float64(numSims)
+				gainHistory[j] = append(gainHistory[j], minerRes.AdjustedRelativeGain)
+			}
+		}
+		for j, config := range configs {
+			avgResults.Miners[j].MinerConfig = config
+			avgResults.Miners[j].AdjustedGainStdDev = calcStdDev(gainHistory[j])
+		}
+
+		results.MultiResults = append(results.MultiResults, avgResults)
+		fmt.Printf("\t(%s)\n", time.Since(simTime))
+		for _, m := range avgResults.Miners {
+			fmt.Printf("\t%s (%s, %.3f): adjusted gain %f\n", m.Name, m.Strategy, m.Share, m.AdjustedRelativeGain)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+
+	"golang.org/x/exp/rand"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//Network describes the honest nodes and how blocks propagate between them, as read from the -network YAML file.
+//When a network is given, gamma is no longer a constant: for every race the honest block is found by a node
+//picked by hashrate, the attacker publishes its block as soon as the honest block reaches it, and every honest
+//node mines on whichever of the two blocks reaches it first.
+type Network struct {
+	Nodes           int          `yaml:"nodes" json:"nodes"`                     //Number of honest nodes
+	Hashrates       []float64    `yaml:"hashrates" json:"hashrates"`             //Relative honest hashrate per node, equal if empty
+	Latency         Distribution `yaml:"latency" json:"latency"`                 //Seconds for a block to go from one honest node to another
+	AttackerLatency Distribution `yaml:"attackerlatency" json:"attackerlatency"` //Seconds between the attacker and a node it is connected to
+	Connectivity    float64      `yaml:"connectivity" json:"connectivity"`       //Fraction of nodes directly connected to the attacker
+	ReactionTime    float64      `yaml:"reactiontime" json:"reactiontime"`       //Seconds the attacker needs to publish once it sees the honest block
+	shares          []float64
+	connected       []bool
+}
+
+//loadNetwork reads a network from a YAML file.
+func loadNetwork(fileName string) (*Network, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var network Network
+	if err := yaml.NewDecoder(bufio.NewReader(f)).Decode(&network); err != nil {
+		return nil, err
+	}
+	if network.Nodes < 1 {
+		network.Nodes = len(network.Hashrates)
+	}
+	if network.Nodes < 1 || (len(network.Hashrates) > 0 && len(network.Hashrates) != network.Nodes) {
+		return nil, fmt.Errorf("network needs nodes or one hashrate per node")
+	}
+	if network.Connectivity < 0.0 || network.Connectivity > 1.0 {
+		return nil, fmt.Errorf("invalid connectivity %f", network.Connectivity)
+	}
+	network.init()
+	return &network, nil
+}
+
+//init normalizes the node hashrates and picks the nodes the attacker is connected to.
+func (network *Network) init() {
+	network.shares = make([]float64, network.Nodes)
+	total := 0.0
+	for i := range network.shares {
+		network.shares[i] = 1.0
+		if len(network.Hashrates) > 0 {
+			network.shares[i] = network.Hashrates[i]
+		}
+		total += network.shares[i]
+	}
+	for i := range network.shares {
+		network.shares[i] /= total
+	}
+
+	//The attacker is always connected to at least one node
+	network.connected = make([]bool, network.Nodes)
+	numConnected := int(math.Max(1, math.Round(network.Connectivity*float64(network.Nodes))))
+	for _, i := range rand.Perm(network.Nodes)[:numConnected] {
+		network.connected[i] = true
+	}
+}
+
+//pickNode returns an honest node, chosen by hashrate.
+func (network *Network) pickNode() int {
+	pick := rand.Float64()
+	for i, share := range network.shares {
+		if pick < share {
+			return i
+		}
+		pick -= share
+	}
+	return network.Nodes - 1
+}
+
+//latency draws the time for a block to go from one honest node to another.
+func (network *Network) latency(from, to int) float64 {
+	if from == to {
+		return 0
+	}
+	return math.Max(0, network.Latency.Rand())
+}
+
+//attackerArrivals returns the time, after the attacker publishes, its block reaches every node.
+//Nodes not connected to the attacker get it through the connected node that relays it first.
+func (network *Network) attackerArrivals() []float64 {
+	direct := make([]float64, network.Nodes)
+	for i := range direct {
+		direct[i] = math.Inf(1)
+		if network.connected[i] {
+			direct[i] = math.Max(0, network.AttackerLatency.Rand())
+		}
+	}
+	arrivals := make([]float64, network.Nodes)
+	for j := range arrivals {
+		arrivals[j] = direct[j]
+		for i := range direct {
+			if network.connected[i] && i != j {
+				arrivals[j] = math.Min(arrivals[j], direct[i]+network.latency(i, j))
+			}
+		}
+	}
+	return arrivals
+}
+
+//raceGamma simulates the propagation of an honest block and the attacker's competing block and
+//returns the fraction of the honest hashrate that mines on the attacker's block.
+func (network *Network) raceGamma() float64 {
+	finder := network.pickNode()
+
+	//The attacker learns of the honest block through the connected node that hears of it first
+	seen := math.Inf(1)
+	for i := range network.connected {
+		if network.connected[i] {
+			seen = math.Min(seen, network.latency(finder, i)+math.Max(0, network.AttackerLatency.Rand()))
+		}
+	}
+	published := seen + network.ReactionTime
+
+	gamma := 0.0
+	attackerArrivals := network.attackerArrivals()
+	for j, share := range network.shares {
+		//The finder always mines on its own block
+		if j != finder && published+attackerArrivals[j] < network.latency(finder, j) {
+			gamma += share
+		}
+	}
+	return gamma
+}
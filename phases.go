@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//Phases splits the simulated blocks into an honest phase, where the attacker mines and publishes like an honest
+//miner, an attack phase running its strategy, and a recovery phase where it is honest again. Written as
+//honest,attack,recovery in blocks, e.g. 1000,2000,3000.
+type Phases struct {
+	Spec     string `json:"spec"`
+	Honest   int    `json:"honest"`
+	Attack   int    `json:"attack"`
+	Recovery int    `json:"recovery"`
+}
+
+var phaseNames = []string{"honest", "attack", "recovery"}
+
+//PhaseResult holds the metrics of the main chain blocks of one phase
+type PhaseResult struct {
+	Phase                    string  `json:"phase"`
+	Blocks                   float64 `json:"blocks"`
+	Duration                 float64 `json:"duration"` //Real seconds
+	MeanBlockInterval        float64 `json:"meanblockinterval"`
+	DifficultyDeviation      float64 `json:"difficultydeviation"` //Mean relative deviation of the difficulty from the total hashrate
+	AttackerShare            float64 `json:"attackershare"`       //Fraction of the blocks mined by the attacker
+	ExcessEmission           float64 `json:"excessemission"`      //Subsidy emitted beyond one block per expected block time
+	CumulativeExcessEmission float64 `json:"cumulativeexcessemission"`
+	RecoveryTime             float64 `json:"recoverytime,omitempty"` //Recovery phase only, see ChainDistortion
+}
+
+//parsePhases parses a phase spec.
+func parsePhases(spec string) (*Phases, error) {
+	parts := strings.Split(strings.TrimSpace(spec), ",")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("phases need honest,attack,recovery blocks in %q", spec)
+	}
+	var lengths [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid %s phase length %q", phaseNames[i], part)
+		}
+		lengths[i] = n
+	}
+	if lengths[1] == 0 || lengths[0]+lengths[1]+lengths[2] < 2 {
+		return nil, fmt.Errorf("phases need an attack and at least 2 blocks in %q", spec)
+	}
+	return &Phases{Spec: spec, Honest: lengths[0], Attack: lengths[1], Recovery: lengths[2]}, nil
+}
+
+//total returns the number of simulated blocks of all phases.
+func (p *Phases) total() int {
+	return p.Honest + p.Attack + p.Recovery
+}
+
+//phase returns the index of the phase the k-th simulated block (from 0) belongs to.
+func (p *Phases) phase(k int) int {
+	switch {
+	case k < p.Honest:
+		return 0
+	case k < p.Honest+p.Attack:
+		return 1
+	default:
+		return 2
+	}
+}
+
+//publicStep simulates the next block while both miners mine on the tip and publish immediately. The attacker's
+//blocks are stamped with its timestamp strategy if applyStrategy, else with the real time.
+func (sim *Simulation) publicStep(applyStrategy bool) {
+	isHonest := !sim.nextCommonBlock()
+	sim.resolveHonestFork(isHonest)
+	if isHonest {
+		sim.blockchain.newBlock(sim.realTime)
+		sim.maybeHonestFork()
+		return
+	}
+	timestamp := sim.realTime
+	if applyStrategy {
+		timestamp = attackerTimestamps.stamp(sim.realTime, sim.timewarpOffset, sim.blockchain.chain[sim.blockchain.height], &sim.blockchain)
+	}
+	sim.blockchain.newPublicBlock(timestamp, false)
+}
+
+//phaseStep simulates the next block if the attacker is honest in the current phase, ending the attack once its phase
+//is over. It returns false during the attack phase.
+func (sim *Simulation) phaseStep() bool {
+	switch sim.phases.phase(sim.blockchain.height + 1 - sim.blockchain.startingBlocks) {
+	case 1:
+		return false
+	case 2:
+		if sim.attackEnd == 0 {
+			sim.endAttack()
+		}
+	}
+	sim.publicStep(false)
+	return true
+}
+
+//endAttack publishes the private branch if it beats the main chain, abandons it otherwise, and turns the attacker honest.
+func (sim *Simulation) endAttack() {
+	log.WithField("Height", sim.blockchain.height).Debug("endAttack")
+	if len(sim.blockchain.privateBranch) > 0 {
+		mainWork, privWork := sim.postForkWork()
+		if privWork > mainWork {
+			sim.blockchain.reorg()
+		} else {
+			sim.blockchain.clearPrivateBrach()
+		}
+	}
+	sim.blockchain.nextPrivateDifficulty = sim.blockchain.nextDifficulty
+	sim.blockchain.setForkHeight(0)
+	sim.sniping = false
+	sim.snipeRace = false
+	sim.setState(0)
+	sim.attackEnd = sim.realTime
+}
+
+//phaseResults measures every phase of the simulated main chain, given its distortion.
+func (sim *Simulation) phaseResults(d ChainDistortion) []PhaseResult {
+	r := sim.blockchain.rewards
+	results := make([]PhaseResult, len(phaseNames))
+	for i := range results {
+		results[i].Phase = phaseNames[i]
+	}
+	deviations := make([]float64, len(results))
+	attackerBlocks := make([]float64, len(results))
+	prevMinedAt := sim.blockchain.chain[sim.blockchain.startingBlocks-1].minedAt
+	for _, block := range sim.blockchain.chain[sim.blockchain.startingBlocks:] {
+		p := sim.phases.phase(block.height - sim.blockchain.startingBlocks)
+		honest, selfish := sim.hashratesAt(block.minedAt)
+		results[p].Blocks++
+		results[p].Duration += float64(block.minedAt - prevMinedAt)
+		if honest+selfish > 0 {
+			deviations[p] += block.difficulty/(honest+selfish) - 1
+		}
+		if !block.isHonest {
+			attackerBlocks[p]++
+		}
+		prevMinedAt = block.minedAt
+	}
+
+	blocks, elapsed := 0.0, 0.0
+	for i := range results {
+		res := &results[i]
+		if res.Blocks == 0 {
+			continue
+		}
+		res.MeanBlockInterval = res.Duration / res.Blocks
+		res.DifficultyDeviation = deviations[i] / res.Blocks
+		res.AttackerShare = attackerBlocks[i] / res.Blocks
+
+		//The subsidy only depends on the height, so blocks and scheduled blocks are compared through the same emission
+		emitted := r.scheduledEmission(blocks+res.Blocks) - r.scheduledEmission(blocks)
+		scheduled := r.scheduledEmission((elapsed+res.Duration)/float64(sim.expectedBlockTime)) -
+			r.scheduledEmission(elapsed/float64(sim.expectedBlockTime))
+		blocks += res.Blocks
+		elapsed += res.Duration
+		res.ExcessEmission = emitted - scheduled
+		res.CumulativeExcessEmission = r.scheduledEmission(blocks) - r.scheduledEmission(elapsed/float64(sim.expectedBlockTime))
+	}
+	results[2].RecoveryTime = d.RecoveryTime
+	return results
+}
+
+//averagePhases averages the phase results of several simulations, the recovery time over those that recovered.
+func averagePhases(simResults []SimulationResult) []PhaseResult {
+	avg := make([]PhaseResult, len(phaseNames))
+	recovered := 0.0
+	for i := range avg {
+		avg[i].Phase = phaseNames[i]
+	}
+	for _, res := range simResults {
+		for i, p := range res.Phases {
+			avg[i].Blocks += p.Blocks
+			avg[i].Duration += p.Duration
+			avg[i].MeanBlockInterval += p.MeanBlockInterval
+			avg[i].DifficultyDeviation += p.DifficultyDeviation
+			avg[i].AttackerShare += p.AttackerShare
+			avg[i].ExcessEmission += p.ExcessEmission
+			avg[i].CumulativeExcessEmission += p.CumulativeExcessEmission
+		}
+		if len(res.Phases) > 2 && res.Phases[2].RecoveryTime >= 0 {
+			avg[2].RecoveryTime += res.Phases[2].RecoveryTime
+			recovered++
+		}
+	}
+	n := float64(len(simResults))
+	for i := range avg {
+		avg[i].Blocks /= n
+		avg[i].Duration /= n
+		avg[i].MeanBlockInterval /= n
+		avg[i].DifficultyDeviation /= n
+		avg[i].AttackerShare /= n
+		avg[i].ExcessEmission /= n
+		avg[i].CumulativeExcessEmission /= n
+	}
+	if recovered > 0 {
+		avg[2].RecoveryTime /= recovered
+	} else {
+		avg[2].RecoveryTime = -1
+	}
+	return avg
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	distuv "gonum.org/v1/gonum/stat/distuv"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/palette/moreland"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+	_ "gonum.org/v1/plot/vg/vgimg"
+	_ "gonum.org/v1/plot/vg/vgsvg"
+)
+
+//ResultsRun is the part of AllResults read back from a results file. AllResults itself cannot be decoded since its
+//difficulty parameters are an interface.
+type ResultsRun struct {
+	Daa      string                 `json:"daa"`
+	Strategy string                 `json:"strategy"`
+	Results  []SimulationAvgResults `json:"results"`
+}
+
+//loadResultsRuns reads every run saved in a results file.
+func loadResultsRuns(fileName string) ([]ResultsRun, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var runs []ResultsRun
+	if err := json.NewDecoder(f).Decode(&runs); err != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+	if len(runs) == 0 {
+		return nil, fmt.Errorf("%s has no runs", fileName)
+	}
+	return runs, nil
+}
+
+//gainMetric is a gain that can be plotted or compared, with the standard deviation of its simulations
+type gainMetric struct {
+	label  string
+	value  func(r SimulationAvgResults) float64
+	stdDev func(r SimulationAvgResults) float64
+}
+
+var gainMetrics = map[string]gainMetric{
+	"adjustedrelativegain": {"Adjusted relative gain",
+		func(r SimulationAvgResults) float64 { return r.AdjustedRelativeGain },
+		func(r SimulationAvgResults) float64 { return r.AdjustedGainStdDev }},
+	"relativegain": {"Relative gain",
+		func(r SimulationAvgResults) float64 { return r.RelativeGain },
+		func(r SimulationAvgResults) float64 { return r.GainStdDev }},
+}
+
+//gainGrid is the metric of the results of one timewarp over alpha (columns) and gamma (rows), NaN where not simulated
+type gainGrid struct {
+	alphas, gammas []float64
+	z              [][]float64
+}
+
+func (g gainGrid) Dims() (c, r int)   { return len(g.alphas), len(g.gammas) }
+func (g gainGrid) Z(c, r int) float64 { return g.z[c][r] }
+func (g gainGrid) X(c int) float64    { return g.alphas[c] }
+func (g gainGrid) Y(r int) float64    { return g.gammas[r] }
+
+//runPlot implements the plot subcommand: heatmaps over alpha and gamma and line plots over alpha for every timewarp
+//of a run in a results file.
+func runPlot(args []string) error {
+	fs := flag.NewFlagSet("plot", flag.ExitOnError)
+	in := fs.String("in", resultFileName, "Results file to plot")
+	run := fs.Int("run", -1, "Index of the run in the results file, negative to count from the last")
+	out := fs.String("out", "plots", "Directory to write the plots to")
+	format := fs.String("format", "svg", "Image format. Options: svg, png")
+	metric := fs.String("metric", "adjustedrelativegain", "Gain to plot. Options: adjustedrelativegain, relativegain")
+	fs.Parse(args)
+
+	m, ok := gainMetrics[strings.ToLower(*metric)]
+	if !ok {
+		return fmt.Errorf("invalid metric %q", *metric)
+	}
+	*format = strings.ToLower(*format)
+	if *format != "svg" && *format != "png" {
+		return fmt.Errorf("invalid format %q", *format)
+	}
+	runs, err := loadResultsRuns(*in)
+	if err != nil {
+		return err
+	}
+	i := *run
+	if i < 0 {
+		i += len(runs)
+	}
+	if i < 0 || i >= len(runs) {
+		return fmt.Errorf("%s has %d runs", *in, len(runs))
+	}
+	if len(runs[i].Results) == 0 {
+		return fmt.Errorf("run %d of %s has no single-attacker results", i, *in)
+	}
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		return err
+	}
+
+	byTimewarp := make(map[int][]SimulationAvgResults)
+	var timewarps []int
+	for _, r := range runs[i].Results {
+		if _, ok := byTimewarp[r.Timewarp]; !ok {
+			timewarps = append(timewarps, r.Timewarp)
+		}
+		byTimewarp[r.Timewarp] = append(byTimewarp[r.Timewarp], r)
+	}
+	sort.Ints(timewarps)
+
+	for _, timewarp := range timewarps {
+		name := filepath.Join(*out, fmt.Sprintf("%s_tw%d", runs[i].Daa, timewarp))
+		title := fmt.Sprintf("%s, timewarp %d", strings.ToUpper(runs[i].Daa), timewarp)
+		if err := plotHeatmap(byTimewarp[timewarp], m, title, name+"_heatmap."+*format, *format); err != nil {
+			return err
+		}
+		if err := plotAlphaLines(byTimewarp[timewarp], m, title, name+"_alpha."+*format, *format); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %s_heatmap.%s and %s_alpha.%s\n", name, *format, name, *format)
+	}
+	return nil
+}
+
+//sortedUnique returns the distinct values, sorted.
+func sortedUnique(values []float64) []float64 {
+	sort.Float64s(values)
+	var unique []float64
+	for i, v := range values {
+		if i == 0 || v != values[i-1] {
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+//indexOf returns the index of v in the sorted values.
+func indexOf(values []float64, v float64) int {
+	return sort.SearchFloat64s(values, v)
+}
+
+//plotHeatmap draws the metric over alpha and gamma, with a color bar centered on a gain of 0.
+func plotHeatmap(results []SimulationAvgResults, m gainMetric, title, fileName, format string) error {
+	var alphas, gammas []float64
+	for _, r := range results {
+		alphas = append(alphas, r.Alpha)
+		gammas = append(gammas, r.Gamma)
+	}
+	grid := gainGrid{alphas: sortedUnique(alphas), gammas: sortedUnique(gammas)}
+	grid.z = make([][]float64, len(grid.alphas))
+	for c := range grid.z {
+		grid.z[c] = make([]float64, len(grid.gammas))
+		for r := range grid.z[c] {
+			grid.z[c][r] = math.NaN()
+		}
+	}
+	maxAbs := 0.0
+	for _, r := range results {
+		v := m.value(r)
+		grid.z[indexOf(grid.alphas, r.Alpha)][indexOf(grid.gammas, r.Gamma)] = v
+		maxAbs = math.Max(maxAbs, math.Abs(v))
+	}
+	if maxAbs == 0 {
+		maxAbs = 1
+	}
+
+	cm := moreland.SmoothBlueRed()
+	cm.SetMin(-maxAbs)
+	cm.SetMax(maxAbs)
+	heat := plotter.NewHeatMap(grid, cm.Palette(255))
+	heat.Min, heat.Max = -maxAbs, maxAbs
+	heat.NaN = color.Gray{Y: 200}
+
+	p := plot.New()
+	p.Title.Text = title
+	p.X.Label.Text = "Alpha"
+	p.Y.Label.Text = "Gamma"
+	p.Add(heat)
+
+	bar := plot.New()
+	bar.Add(&plotter.ColorBar{ColorMap: cm, Vertical: true})
+	bar.HideX()
+	bar.Title.Text = "Gain"
+
+	const width, height, barWidth = 7 * vg.Inch, 5 * vg.Inch, 1.3 * vg.Inch
+	c, err := draw.NewFormattedCanvas(width, height, format)
+	if err != nil {
+		return err
+	}
+	dc := draw.New(c)
+	p.Draw(draw.Crop(dc, 0, -barWidth, 0, 0))
+	bar.Draw(draw.Crop(dc, width-barWidth, 0, 0, 0))
+	return writeCanvas(c, fileName)
+}
+
+//plotAlphaLines draws the metric over alpha, one line per gamma, with 95% confidence bands of the mean.
+func plotAlphaLines(results []SimulationAvgResults, m gainMetric, title, fileName, format string) error {
+	byGamma := make(map[float64][]SimulationAvgResults)
+	var gammas []float64
+	for _, r := range results {
+		byGamma[r.Gamma] = append(byGamma[r.Gamma], r)
+		gammas = append(gammas, r.Gamma)
+	}
+	gammas = sortedUnique(gammas)
+
+	p := plot.New()
+	p.Title.Text = title
+	p.X.Label.Text = "Alpha"
+	p.Y.Label.Text = m.label
+	p.Add(plotter.NewGrid())
+
+	for i, gamma := range gammas {
+		points := byGamma[gamma]
+		sort.Slice(points, func(a, b int) bool { return points[a].Alpha < points[b].Alpha })
+		line := make(plotter.XYs, len(points))
+		band := make(plotter.XYs, 2*len(points))
+		for j, r := range points {
+			//95% interval of the mean with the Student t quantile, as runs are often few
+			var ci float64
+			if r.NumSims > 1 {
+				q := distuv.StudentsT{Mu: 0, Sigma: 1, Nu: float64(r.NumSims - 1)}.Quantile(0.975)
+				ci = q * sampleStdDev(m.stdDev(r), r.NumSims) / math.Sqrt(float64(r.NumSims))
+			}
+			line[j] = plotter.XY{X: r.Alpha, Y: m.value(r)}
+			band[j] = plotter.XY{X: r.Alpha, Y: m.value(r) + ci}
+			band[len(band)-1-j] = plotter.XY{X: r.Alpha, Y: m.value(r) - ci}
+		}
+
+		lineColor := plotutil.Color(i)
+		red, green, blue, _ := lineColor.RGBA()
+		polygon, err := plotter.NewPolygon(band)
+		if err != nil {
+			return err
+		}
+		polygon.Color = color.NRGBA{R: uint8(red >> 8), G: uint8(green >> 8), B: uint8(blue >> 8), A: 60}
+		polygon.LineStyle.Width = 0
+		l, err := plotter.NewLine(line)
+		if err != nil {
+			return err
+		}
+		l.Color = lineColor
+		p.Add(polygon, l)
+		p.Legend.Add(fmt.Sprintf("gamma %g", gamma), l)
+	}
+
+	c, err := draw.NewFormattedCanvas(7*vg.Inch, 5*vg.Inch, format)
+	if err != nil {
+		return err
+	}
+	p.Draw(draw.New(c))
+	return writeCanvas(c, fileName)
+}
+
+//writeCanvas writes a drawn canvas to a file.
+func writeCanvas(c vg.CanvasWriterTo, fileName string) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if _, err := c.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
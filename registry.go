@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//DifficultyDef describes a difficulty algorithm that can be selected with -algo. Algorithms register themselves
+//with registerDifficulty, their parameters are read from <name>.yaml.
+type DifficultyDef struct {
+	Name      string
+	BlockTime int                                       //Default seconds between blocks
+	Params    Difficulty                                //Default parameters, also the type the YAML file is decoded into
+	Decode    func(d *yaml.Decoder) (Difficulty, error) //Reads the parameters from YAML, decodes into a copy of Params if nil
+	//MinHistory returns the number of blocks the algo reads back from the tip with the given parameters, which the
+	//warm-up must cover. 2 (the tip and its parent) if nil.
+	MinHistory func(params Difficulty) int
+}
+
+var difficultyRegistry = make(map[string]DifficultyDef)
+
+//registryMu guards difficultyRegistry, which the job server reads and extends with formula DAAs from its handlers
+var registryMu sync.RWMutex
+
+//registerDifficulty makes a difficulty algorithm available under its name.
+func registerDifficulty(def DifficultyDef) {
+	if def.Decode == nil {
+		def.Decode = decodeParams(def.Params)
+	}
+	if def.MinHistory == nil {
+		def.MinHistory = func(Difficulty) int { return 2 }
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	difficultyRegistry[def.Name] = def
+}
+
+//decodeParams returns a decoder that reads YAML into a copy of params, so keys missing from the file keep their default.
+func decodeParams(params Difficulty) func(d *yaml.Decoder) (Difficulty, error) {
+	return func(d *yaml.Decoder) (Difficulty, error) {
+		v := reflect.New(reflect.TypeOf(params))
+		v.Elem().Set(reflect.ValueOf(params))
+		if err := d.Decode(v.Interface()); err != nil {
+			return params, err
+		}
+		return v.Elem().Interface().(Difficulty), nil
+	}
+}
+
+//lookupDifficulty returns the difficulty algorithm registered under name. An unregistered name is looked up as a
+//formula DAA in <name>.yaml and registered if it defines one, warning of why if the file exists but does not.
+func lookupDifficulty(name string) (DifficultyDef, bool) {
+	registryMu.RLock()
+	def, ok := difficultyRegistry[name]
+	registryMu.RUnlock()
+	if ok {
+		return def, true
+	}
+	def, err := loadFormulaDefinition(name)
+	if os.IsNotExist(err) {
+		log.WithFields(log.Fields{"Algo": name, "Error": err}).Debug("No formula difficulty algorithm")
+		return def, false
+	} else if err != nil {
+		log.WithFields(log.Fields{"Algo": name, "Error": err}).Warn("Invalid formula difficulty algorithm")
+		return def, false
+	}
+	registerDifficulty(def)
+	return def, true
+}
+
+//difficultyNames returns the names of the registered difficulty algorithms, sorted.
+func difficultyNames() (names []string) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for name := range difficultyRegistry {
+		names = append(names, strings.ToUpper(name))
+	}
+	sort.Strings(names)
+	return
+}
+
+//formulaDifficulty is a window-based difficulty algorithm defined in YAML rather than Go. Every Retarget blocks, the
+//work of the last Window blocks (ignoring the Delay most recent ones) is divided by the time they took:
+//	time:	span (last timestamp minus first), mtp (difference of median times past) or trimmed (span of the
+//		timestamps left once sorted and the Outliers earliest and latest are dropped)
+//	work:	sum (of difficulties), harmonic (Window over the mean target) or last (Window times the tip difficulty)
+//The time is measured from the block before the window, unless OffByOne like BTC. It is then dampened towards the
+//target timespan and clamped to [MinSpan, MaxSpan] times it.
+type formulaDifficulty struct {
+	Window         int     `yaml:"window" json:"window"`
+	Delay          int     `yaml:"delay" json:"delay"`
+	Retarget       int     `yaml:"retarget" json:"retarget"` //Blocks between adjustments, 1 if 0
+	Time           string  `yaml:"time" json:"time"`
+	Mediantimepast int     `yaml:"mediantimepast" json:"mediantimepast"`
+	Outliers       int     `yaml:"outliers" json:"outliers"`
+	Work           string  `yaml:"work" json:"work"`
+	OffByOne       bool    `yaml:"offbyone" json:"offbyone"`
+	Dampening      float64 `yaml:"dampening" json:"dampening"` //1 if 0
+	MinSpan        float64 `yaml:"minspan" json:"minspan"`     //0 for no clamp
+	MaxSpan        float64 `yaml:"maxspan" json:"maxspan"`     //0 for no clamp
+}
+
+//formulaFile is the layout of a YAML file defining a formula DAA
+type formulaFile struct {
+	Formula   *formulaDifficulty `yaml:"formula"`
+	BlockTime int                `yaml:"blocktime"`
+}
+
+//loadFormulaDefinition reads a formula DAA from <name>.yaml.
+func loadFormulaDefinition(name string) (DifficultyDef, error) {
+	def := DifficultyDef{Name: name}
+	f, err := os.Open(name + ".yaml")
+	if err != nil {
+		return def, err
+	}
+	defer f.Close()
+
+	var file formulaFile
+	if err := yaml.NewDecoder(bufio.NewReader(f)).Decode(&file); err != nil {
+		return def, err
+	}
+	if file.Formula == nil {
+		return def, fmt.Errorf("%s.yaml has no formula section", name)
+	}
+	if err := file.Formula.validate(); err != nil {
+		return def, err
+	}
+	def.BlockTime = file.BlockTime
+	if def.BlockTime <= 0 {
+		def.BlockTime = 600
+	}
+	def.Params = *file.Formula
+	def.MinHistory = formulaHistory
+	def.Decode = func(d *yaml.Decoder) (Difficulty, error) {
+		var file formulaFile
+		if err := d.Decode(&file); err != nil || file.Formula == nil {
+			return def.Params, err
+		}
+		return *file.Formula, file.Formula.validate()
+	}
+	return def, nil
+}
+
+//validate checks the formula can be evaluated on the starting blocks.
+func (f formulaDifficulty) validate() error {
+	switch f.Time {
+	case "span", "trimmed":
+	case "mtp":
+		if f.Mediantimepast < 1 {
+			return fmt.Errorf("mtp time needs mediantimepast")
+		}
+	default:
+		return fmt.Errorf("invalid time aggregation %q", f.Time)
+	}
+	switch f.Work {
+	case "sum", "harmonic", "last":
+	default:
+		return fmt.Errorf("invalid work aggregation %q", f.Work)
+	}
+	if f.Window < 2 || f.Delay < 0 || f.Retarget < 0 || f.Outliers < 0 || 2*f.Outliers >= f.Window || f.Dampening < 0 {
+		return fmt.Errorf("invalid formula window")
+	}
+	return nil
+}
+
+//formulaHistory is the window, the delayed blocks, the block before the window and its median time past.
+func formulaHistory(params Difficulty) int {
+	f := params.(formulaDifficulty)
+	return f.Window + f.Delay + f.Mediantimepast + 1
+}
+
+func (f formulaDifficulty) getDiff(isPrivate bool, blockchain Blockchain) float64 {
+	var chain []Block
+	if isPrivate {
+		chain = blockchain.getPrivateView()
+	} else {
+		chain = blockchain.chain
+	}
+	chainLen := len(chain)
+
+	if f.Retarget > 1 && (chainLen-blockchain.startingBlocks)%f.Retarget != 0 {
+		return chain[chainLen-1].difficulty
+	}
+
+	end := chainLen - f.Delay
+	window := chain[end-f.Window : end]
+	first := end - f.Window - 1
+	if f.OffByOne {
+		first++
+	}
+
+	var span int
+	switch f.Time {
+	case "span":
+		span = chain[end-1].timestamp - chain[first].timestamp
+	case "mtp":
+		span = median(chain[end-f.Mediantimepast:end]).timestamp - median(chain[first+1-f.Mediantimepast:first+1]).timestamp
+	case "trimmed":
+		var times []int
+		for _, block := range chain[first:end] {
+			times = append(times, block.timestamp)
+		}
+		sort.Ints(times)
+		times = times[f.Outliers : len(times)-f.Outliers]
+		span = times[len(times)-1] - times[0]
+	}
+
+	var work float64
+	switch f.Work {
+	case "sum":
+		work = sumBlocks(window...)
+	case "harmonic":
+		targets := 0.0
+		for _, block := range window {
+			targets += 1.0 / block.difficulty
+		}
+		work = float64(f.Window) * float64(f.Window) / targets
+	case "last":
+		work = float64(f.Window) * chain[chainLen-1].difficulty
+	}
+
+	targetSpan := float64(f.Window * blockchain.expectedBlockTime)
+	actualSpan := float64(span)
+	if f.Dampening > 1 {
+		actualSpan = targetSpan + (actualSpan-targetSpan)/f.Dampening
+	}
+	if f.MinSpan > 0 {
+		actualSpan = math.Max(actualSpan, f.MinSpan*targetSpan)
+	}
+	if f.MaxSpan > 0 {
+		actualSpan = math.Min(actualSpan, f.MaxSpan*targetSpan)
+	}
+	//A zero or negative span can only come from timewarped timestamps, keep it within a second
+	actualSpan = math.Max(actualSpan, 1)
+
+	return work * float64(blockchain.expectedBlockTime) / actualSpan
+}
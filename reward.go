@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"math"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	distuv "gonum.org/v1/gonum/stat/distuv"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//RewardModel holds the subsidy schedule and fee distribution of a chain.
+//The subsidy either decays by ReductionFactor every ReductionInterval blocks (BTC halvings, DASH reductions)
+//or, if MoneySupply is set, follows the smooth emission curve of XMR. TailEmission is the minimum subsidy.
+//Fees either arrive in the mempool at FeeRate coins per second of real time, as for every preset, or, if FeeRate is 0,
+//each block finds a Gamma distributed amount of new fees. Fees a block leaves behind stay in the mempool.
+type RewardModel struct {
+	InitialSubsidy      float64 `yaml:"initialsubsidy" json:"initialsubsidy"`
+	ReductionInterval   int     `yaml:"reductioninterval" json:"reductioninterval"` //Blocks between subsidy reductions, 0 for none
+	ReductionFactor     float64 `yaml:"reductionfactor" json:"reductionfactor"`     //Subsidy multiplier at every reduction, 0.5 for halvings
+	TailEmission        float64 `yaml:"tailemission" json:"tailemission"`
+	MoneySupply         float64 `yaml:"moneysupply" json:"moneysupply"`                 //Total supply for smooth emission, 0 to use reductions
+	EmissionSpeedFactor uint    `yaml:"emissionspeedfactor" json:"emissionspeedfactor"` //Subsidy is (MoneySupply - emitted) / 2^EmissionSpeedFactor
+	StartHeight         int     `yaml:"startheight" json:"startheight"`                 //Real chain height of the first simulated block
+	StartEmitted        float64 `yaml:"startemitted" json:"startemitted"`               //Coins emitted before StartHeight (smooth emission)
+	FeeMean             float64 `yaml:"feemean" json:"feemean"`                         //Mean total fees per block
+	FeeShape            float64 `yaml:"feeshape" json:"feeshape"`                       //Gamma shape of fees per block, lower is burstier
+	FeeRate             float64 `yaml:"feerate" json:"feerate"`                         //Fees arriving in the mempool per second, 0 to draw per block
+	MaxBlockFees        float64 `yaml:"maxblockfees" json:"maxblockfees"`               //Most fees a block can hold (block size limit), 0 for no limit
+	MaxUncles           int     `yaml:"maxuncles" json:"maxuncles"`                     //Uncles a block may reference, 0 disables uncles
+	MaxUncleDepth       int     `yaml:"maxuncledepth" json:"maxuncledepth"`             //Most blocks between an uncle and the block referencing it
+	UncleRewardDivisor  int     `yaml:"unclerewarddivisor" json:"unclerewarddivisor"`   //Uncle at depth d earns (divisor-d)/divisor of the subsidy
+	NephewRewardDivisor int     `yaml:"nephewrewarddivisor" json:"nephewrewarddivisor"` //Each uncle referenced earns subsidy/divisor
+}
+
+var rewardMap = map[string]RewardModel{
+	"btc": {InitialSubsidy: 50, ReductionInterval: 210000, ReductionFactor: 0.5, StartHeight: 840000,
+		FeeMean: 0.15, FeeRate: 0.15 / 600, FeeShape: 2.0},
+	"bch": {InitialSubsidy: 50, ReductionInterval: 210000, ReductionFactor: 0.5, StartHeight: 840000,
+		FeeMean: 0.002, FeeRate: 0.002 / 600, FeeShape: 1.0},
+	//BCH while it ran the EDA, in late 2017
+	"eda": {InitialSubsidy: 50, ReductionInterval: 210000, ReductionFactor: 0.5, StartHeight: 478558,
+		FeeMean: 0.002, FeeRate: 0.002 / 600, FeeShape: 1.0},
+	//EMA and WTEMA were proposed for BCH and are simulated with Bitcoin's rewards
+	"ema": {InitialSubsidy: 50, ReductionInterval: 210000, ReductionFactor: 0.5, StartHeight: 840000,
+		FeeMean: 0.002, FeeRate: 0.002 / 600, FeeShape: 1.0},
+	"wtema": {InitialSubsidy: 50, ReductionInterval: 210000, ReductionFactor: 0.5, StartHeight: 840000,
+		FeeMean: 0.002, FeeRate: 0.002 / 600, FeeShape: 1.0},
+	"dash": {InitialSubsidy: 5, ReductionInterval: 210240, ReductionFactor: 13.0 / 14.0, StartHeight: 2000000,
+		FeeMean: 0.0005, FeeRate: 0.0005 / 150, FeeShape: 1.0},
+	//Dash before DGWv3
+	"kgw": {InitialSubsidy: 5, ReductionInterval: 210240, ReductionFactor: 13.0 / 14.0, StartHeight: 2000000,
+		FeeMean: 0.0005, FeeRate: 0.0005 / 150, FeeShape: 1.0},
+	"dgw1": {InitialSubsidy: 5, ReductionInterval: 210240, ReductionFactor: 13.0 / 14.0, StartHeight: 2000000,
+		FeeMean: 0.0005, FeeRate: 0.0005 / 150, FeeShape: 1.0},
+	"dgw2": {InitialSubsidy: 5, ReductionInterval: 210240, ReductionFactor: 13.0 / 14.0, StartHeight: 2000000,
+		FeeMean: 0.0005, FeeRate: 0.0005 / 150, FeeShape: 1.0},
+	"xmr": {MoneySupply: 18446744.073709551615, EmissionSpeedFactor: 19, TailEmission: 0.6, StartEmitted: 18100000,
+		FeeMean: 0.01, FeeRate: 0.01 / 120, FeeShape: 1.5},
+	"zec": {InitialSubsidy: 12.5, ReductionInterval: 840000, ReductionFactor: 0.5, StartHeight: 1000000,
+		FeeMean: 0.0001, FeeRate: 0.0001 / 150, FeeShape: 1.0},
+	"doge": {InitialSubsidy: 10000, FeeMean: 1.0, FeeRate: 1.0 / 60, FeeShape: 1.0},
+	//BTG forked from Bitcoin and kept its subsidy schedule
+	"btg": {InitialSubsidy: 50, ReductionInterval: 210000, ReductionFactor: 0.5, StartHeight: 840000,
+		FeeMean: 0.001, FeeRate: 0.001 / 600, FeeShape: 1.0},
+	//Proof of work share of the Decred subsidy, reduced by 1/101 every 6144 blocks
+	"dcr":  {InitialSubsidy: 0.8, ReductionInterval: 6144, ReductionFactor: 100.0 / 101.0, FeeMean: 0.001, FeeRate: 0.001 / 300, FeeShape: 1.0},
+	"grin": {InitialSubsidy: 60, FeeMean: 0.01, FeeRate: 0.01 / 60, FeeShape: 1.0},
+	"eth": {InitialSubsidy: 3, FeeMean: 0.05, FeeRate: 0.05 / 13, FeeShape: 1.0,
+		MaxUncles: 2, MaxUncleDepth: 6, UncleRewardDivisor: 8, NephewRewardDivisor: 32},
+	//ECIP-1017 reduces the ETC subsidy by 20% every era of 5M blocks
+	"etc": {InitialSubsidy: 5, ReductionInterval: 5000000, ReductionFactor: 0.8, StartHeight: 19000000,
+		FeeMean: 0.01, FeeRate: 0.01 / 13, FeeShape: 1.0, MaxUncles: 2, MaxUncleDepth: 6, UncleRewardDivisor: 8, NephewRewardDivisor: 32},
+}
+
+//RewardStats holds the revenue, in coins, of each miner on the main chain
+type RewardStats struct {
+	selfishRevenue float64
+	honestRevenue  float64
+	emitted        float64 //Subsidy emitted by the simulated blocks, uncle rewards included
+	uncles         int     //Uncles referenced by the main chain
+}
+
+//credit adds revenue to the honest or selfish miner.
+func (rs *RewardStats) credit(isHonest bool, revenue float64) {
+	if isHonest {
+		rs.honestRevenue += revenue
+	} else {
+		rs.selfishRevenue += revenue
+	}
+}
+
+//subsidy returns the block subsidy at the given real chain height, given the coins emitted so far.
+func (r RewardModel) subsidy(height int, emitted float64) float64 {
+	var s float64
+	if r.MoneySupply > 0 {
+		s = (r.MoneySupply - emitted) / math.Pow(2, float64(r.EmissionSpeedFactor))
+	} else if r.ReductionInterval > 0 {
+		s = r.InitialSubsidy * math.Pow(r.ReductionFactor, float64(height/r.ReductionInterval))
+	} else {
+		s = r.InitialSubsidy
+	}
+	return math.Max(s, r.TailEmission)
+}
+
+//drawFees returns the new fees found by a block when fees are not accumulated over real time.
+func (r RewardModel) drawFees() float64 {
+	if r.FeeMean <= 0 {
+		return 0
+	}
+	return distuv.Gamma{
+		Alpha: r.FeeShape,
+		Beta:  r.FeeShape / r.FeeMean,
+		Src:   randsrc,
+	}.Rand()
+}
+
+//blockFees returns the fees claimed by a block mined at realTime on top of parent, and the fees it leaves in the mempool.
+//undercut is the fraction of the available fees the miner deliberately leaves behind.
+func (r RewardModel) blockFees(parent Block, realTime int, undercut float64) (fees, backlog float64) {
+	available := parent.backlog
+	if r.FeeRate > 0 {
+		available += r.FeeRate * float64(realTime-parent.minedAt)
+	} else {
+		available += r.drawFees()
+	}
+	fees = available
+	if r.MaxBlockFees > 0 && fees > r.MaxBlockFees {
+		fees = r.MaxBlockFees
+	}
+	fees *= 1 - undercut
+	backlog = available - fees
+	return
+}
+
+//expectedFees returns the fees a block is expected to find when mined after blockTime seconds on an empty mempool.
+func (r RewardModel) expectedFees(blockTime int) float64 {
+	fees := r.FeeMean
+	if r.FeeRate > 0 {
+		fees = r.FeeRate * float64(blockTime)
+	}
+	if r.MaxBlockFees > 0 && fees > r.MaxBlockFees {
+		fees = r.MaxBlockFees
+	}
+	return fees
+}
+
+//rewardStats walks the simulated part of the main chain and totals the subsidy and fees earned by each miner.
+func (blockchain *Blockchain) rewardStats() (rs RewardStats) {
+	rs.emitted, rs.uncles = blockchain.walkRewards(func(block Block, revenue float64) {
+		rs.credit(block.isHonest, revenue)
+	})
+	return
+}
+
+//walkRewards walks the simulated part of the main chain and calls credit with every block (or uncle)
+//and the revenue it earned. It returns the subsidy emitted and the number of uncles referenced.
+func (blockchain *Blockchain) walkRewards(credit func(block Block, revenue float64)) (emitted float64, uncles int) {
+	r := blockchain.rewards
+	emitted = r.StartEmitted
+	for _, block := range blockchain.chain[blockchain.startingBlocks:] {
+		subsidy := r.subsidy(r.StartHeight+block.height-blockchain.startingBlocks, emitted)
+		reward := subsidy
+		for _, id := range block.uncles {
+			uncle := blockchain.tree[id]
+			uncleReward := subsidy * float64(r.UncleRewardDivisor-(block.height-uncle.height)) / float64(r.UncleRewardDivisor)
+			credit(uncle, uncleReward)
+			reward += subsidy / float64(r.NephewRewardDivisor)
+			emitted += uncleReward
+			uncles++
+		}
+		emitted += reward
+		credit(block, reward+block.fees)
+	}
+	emitted -= r.StartEmitted
+	return
+}
+
+//loadRewardModel returns the reward model for the algo, overridden by the reward section of its YAML file if present.
+func loadRewardModel(algo string) RewardModel {
+	var temp struct {
+		Reward *RewardModel `yaml:"reward"`
+	}
+	f, err := os.Open(algo + ".yaml")
+	if err == nil {
+		defer f.Close()
+		if err := yaml.NewDecoder(bufio.NewReader(f)).Decode(&temp); err != nil {
+			log.WithField("Error", err).Warn("Failed to decode reward model, using defaults")
+		}
+	}
+	if temp.Reward != nil {
+		return *temp.Reward
+	}
+	return rewardMap[algo]
+}
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//Job is a simulation submitted to the serve subcommand, run as its own selfish_go process with the given flags
+type Job struct {
+	ID        int               `json:"id"`
+	Params    map[string]string `json:"params"`
+	Status    string            `json:"status"` //queued, running, finished, failed or cancelled
+	Points    int               `json:"points"` //Parameter points of the sweep, 0 until the job has started
+	Done      int               `json:"done"`
+	Progress  float64           `json:"progress"`
+	Submitted time.Time         `json:"submitted"`
+	Started   *time.Time        `json:"started,omitempty"`
+	Finished  *time.Time        `json:"finished,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Results   string            `json:"results,omitempty"` //File served by /jobs/id/results
+	Files     []string          `json:"files,omitempty"`   //Files uploaded with the job, kept in its directory
+
+	cmd *exec.Cmd
+}
+
+//maxQueuedJobs is the number of jobs that can wait for a worker
+const maxQueuedJobs = 256
+
+//maxJobBytes is the largest request body of a submitted job, uploaded files included
+const maxJobBytes = 32 << 20
+
+//serveReservedParams are flags set by the server for every job
+var serveReservedParams = map[string]bool{"results": true}
+
+//serveParams are the flags a job may set besides serveFileParams. -validate and -validateout are left out, as
+//validation is not a simulation.
+var serveParams = map[string]bool{
+	"algo": true, "numsims": true, "numblocks": true, "timewarp": true, "blocktime": true,
+	"alpha": true, "gamma": true, "alphamax": true, "alphastep": true, "gammamax": true, "gammastep": true,
+	"timewarpmax": true, "timewarpstep": true, "strategy": true,
+	"snipethreshold": true, "snipegiveup": true, "undercut": true, "undercutgamma": true,
+	"propdelay": true, "honesthashrate": true, "selfishhashrate": true, "warmup": true, "warmupmethod": true,
+	"phases": true, "timestamps": true, "clockoffset": true, "clockjitter": true, "clockminers": true,
+	"forkchoice": true, "output-format": true, "loglevel": true,
+}
+
+//serveFileParams are the flags naming a file, and serveFileSpecs the methods of the specs that read one, such as
+//csv:file. A job may only name files uploaded with it, so that it cannot reach files outside its directory.
+var serveFileParams = map[string]bool{"miners": true, "network": true, "twochain": true}
+var serveFileSpecs = map[string]string{"honesthashrate": "csv", "selfishhashrate": "csv", "timestamps": "file", "warmupmethod": "history"}
+
+//specFile returns the file named by a spec that reads one, such as csv:file, and whether the spec reads a file.
+func specFile(name, value string) (string, bool) {
+	parts := strings.SplitN(strings.TrimSpace(value), ":", 2)
+	method, ok := serveFileSpecs[name]
+	if !ok || len(parts) != 2 || !strings.EqualFold(parts[0], method) {
+		return "", false
+	}
+	return parts[1], true
+}
+
+//jobArg returns the command line value of a job flag, files being named inside the job directory.
+func jobArg(dir, name, value string) string {
+	if serveFileParams[name] {
+		return filepath.Join(dir, value)
+	}
+	if file, ok := specFile(name, value); ok {
+		return serveFileSpecs[name] + ":" + filepath.Join(dir, file)
+	}
+	return value
+}
+
+//checkUploadName returns an error unless name can be the name of a file uploaded with a job: a plain file name that
+//is not one of the files the server keeps in the job directory.
+func checkUploadName(name string) error {
+	if name == "" || name == "." || name == ".." || filepath.Base(name) != name || strings.ContainsAny(name, `/\`) ||
+		name == "job.json" || name == "output.log" || name == resultFileName || strings.HasPrefix(name, "results_") {
+		return fmt.Errorf("invalid file name %q", name)
+	}
+	return nil
+}
+
+//checkServeAlgo returns an error unless algo is the name of a registered or formula DAA.
+func checkServeAlgo(algo string) error {
+	algo = strings.ToLower(algo)
+	if strings.ContainsAny(algo, `/\.:`) {
+		return fmt.Errorf("invalid algo %q", algo)
+	}
+	if _, ok := lookupDifficulty(algo); !ok {
+		return fmt.Errorf("invalid algo %q", algo)
+	}
+	return nil
+}
+
+//jobServer runs the submitted jobs on a fixed number of workers, keeping every job in its own directory
+type jobServer struct {
+	mu         sync.Mutex
+	jobs       map[int]*Job
+	nextID     int
+	dir        string
+	executable string
+	queue      chan *Job
+}
+
+//runServe implements the serve subcommand: a local HTTP API to submit, monitor and cancel simulation jobs and
+//download their results.
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:8080", "Address to listen on")
+	dir := fs.String("dir", "jobs", "Directory to keep the jobs and their results in")
+	workers := fs.Int("workers", 1, "Number of jobs run at once. Every job already runs its simulations in parallel")
+	fs.Parse(args)
+
+	if *workers < 1 {
+		return fmt.Errorf("invalid number of workers %d", *workers)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		return err
+	}
+	s := &jobServer{jobs: make(map[int]*Job), nextID: 1, dir: *dir, executable: executable, queue: make(chan *Job, maxQueuedJobs)}
+	if err := s.loadJobs(); err != nil {
+		return err
+	}
+	for i := 0; i < *workers; i++ {
+		go s.work()
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs", s.handleJobs)
+	mux.HandleFunc("/jobs/", s.handleJob)
+	fmt.Printf("Serving jobs on http://%s with %d workers\n", *addr, *workers)
+	return http.ListenAndServe(*addr, mux)
+}
+
+//jobDir returns the directory of a job.
+func (s *jobServer) jobDir(id int) string {
+	return filepath.Join(s.dir, strconv.Itoa(id))
+}
+
+//loadJobs reads back the jobs of a previous server. Jobs it left queued or running are marked failed.
+func (s *jobServer) loadJobs() error {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*", "job.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		if job.Status == "queued" || job.Status == "running" {
+			job.Status = "failed"
+			job.Error = "server stopped before the job finished"
+		}
+		s.jobs[job.ID] = &job
+		if job.ID >= s.nextID {
+			s.nextID = job.ID + 1
+		}
+	}
+	return nil
+}
+
+//saveJob writes the state of a job to its directory. The caller holds the lock.
+func (s *jobServer) saveJob(job *Job) {
+	data, _ := json.MarshalIndent(job, "", "\t")
+	if err := os.WriteFile(filepath.Join(s.jobDir(job.ID), "job.json"), data, 0666); err != nil {
+		log.WithFields(log.Fields{"Job": job.ID, "Error": err}).Warn("Failed to save job")
+	}
+}
+
+//update changes a job under the lock and saves it.
+func (s *jobServer) update(job *Job, change func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	change()
+	s.saveJob(job)
+}
+
+//snapshot returns a copy of a job taken under the lock, to be written out once the lock is released.
+func (s *jobServer) snapshot(job *Job) Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *job
+}
+
+//work runs queued jobs one at a time.
+func (s *jobServer) work() {
+	for job := range s.queue {
+		s.run(job)
+	}
+}
+
+//run runs a job to completion, following its progress from the "Parameter points" and "Simulating:" lines printed
+//for every parameter point.
+func (s *jobServer) run(job *Job) {
+	dir := s.jobDir(job.ID)
+	args := []string{"-results", filepath.Join(dir, resultFileName)}
+	names := make([]string, 0, len(job.Params))
+	for name := range job.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, fmt.Sprintf("-%s=%s", name, jobArg(dir, name, job.Params[name])))
+	}
+	cmd := exec.Command(s.executable, args...)
+
+	s.mu.Lock()
+	if job.Status != "queued" {
+		s.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	job.Started = &now
+	stdout, logFile, err := startJob(cmd, filepath.Join(dir, "output.log"))
+	if err != nil {
+		job.Status = "failed"
+		job.Error = err.Error()
+		job.Finished = &now
+		s.saveJob(job)
+		s.mu.Unlock()
+		return
+	}
+	job.Status = "running"
+	job.cmd = cmd
+	s.saveJob(job)
+	s.mu.Unlock()
+
+	s.follow(job, stdout)
+	logFile.Close()
+}
+
+//startJob starts a job process, logging its output to a file. The returned reader copies the standard output to the log.
+func startJob(cmd *exec.Cmd, logName string) (io.Reader, *os.File, error) {
+	logFile, err := os.Create(logName)
+	if err != nil {
+		return nil, nil, err
+	}
+	cmd.Stderr = logFile
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		logFile.Close()
+		return nil, nil, err
+	}
+	return io.TeeReader(stdout, logFile), logFile, nil
+}
+
+//follow tracks the progress of a started job from its output, then waits for it and records how it ended.
+func (s *jobServer) follow(job *Job, stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "Parameter points: ") {
+			points, _ := strconv.Atoi(strings.TrimPrefix(line, "Parameter points: "))
+			s.update(job, func() { job.Points = points })
+		} else if strings.HasPrefix(line, "Simulating:") {
+			s.update(job, func() {
+				job.Done++
+				if job.Points > 0 {
+					job.Progress = float64(job.Done) / float64(job.Points)
+				}
+			})
+		}
+	}
+	err := job.cmd.Wait()
+
+	//Without -output-format json the results are exported to a new file instead of results.json
+	results := ""
+	if exports, _ := filepath.Glob(filepath.Join(s.jobDir(job.ID), "results_*")); len(exports) > 0 {
+		results = filepath.Base(exports[0])
+	} else if _, err := os.Stat(filepath.Join(s.jobDir(job.ID), resultFileName)); err == nil {
+		results = resultFileName
+	}
+	s.update(job, func() {
+		now := time.Now()
+		job.Finished = &now
+		job.cmd = nil
+		job.Results = results
+		switch {
+		case job.Status == "cancelled":
+		case err != nil:
+			job.Status = "failed"
+			job.Error = fmt.Sprintf("%v: %s", err, lastLogLine(filepath.Join(s.jobDir(job.ID), "output.log")))
+		default:
+			job.Status = "finished"
+			job.Progress = 1
+		}
+	})
+}
+
+//lastLogLine returns the last line of a job log, which holds the error of a failed job.
+func lastLogLine(logName string) string {
+	data, _ := os.ReadFile(logName)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	return lines[len(lines)-1]
+}
+
+//submit queues a job with the given flags and files. Flags and specs that read files may only name the uploaded
+//files, and the algos, including those of a two-chain file, must be registered or formula DAAs. The other values are
+//checked by the job itself when it starts.
+func (s *jobServer) submit(params, files map[string]string) (*Job, error) {
+	for name := range files {
+		if err := checkUploadName(name); err != nil {
+			return nil, err
+		}
+	}
+	for name, value := range params {
+		if serveReservedParams[name] {
+			return nil, fmt.Errorf("-%s is set by the server", name)
+		}
+		file, isFile := specFile(name, value)
+		if serveFileParams[name] {
+			file, isFile = value, true
+		} else if !serveParams[name] {
+			return nil, fmt.Errorf("invalid parameter %q", name)
+		}
+		if _, ok := files[file]; isFile && !ok {
+			return nil, fmt.Errorf("-%s must name a file uploaded with the job", name)
+		}
+	}
+	if algo, ok := params["algo"]; ok {
+		if err := checkServeAlgo(algo); err != nil {
+			return nil, err
+		}
+	}
+	if twoChainFile, ok := params["twochain"]; ok {
+		var config TwoChainConfig
+		if err := yaml.Unmarshal([]byte(files[twoChainFile]), &config); err != nil {
+			return nil, fmt.Errorf("%s: %v", twoChainFile, err)
+		}
+		for _, chain := range config.Chains {
+			if err := checkServeAlgo(chain.Algo); err != nil {
+				return nil, fmt.Errorf("%s: %v", twoChainFile, err)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job := &Job{ID: s.nextID, Params: params, Status: "queued", Submitted: time.Now()}
+	if err := os.MkdirAll(s.jobDir(job.ID), 0755); err != nil {
+		return nil, err
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(s.jobDir(job.ID), name), []byte(content), 0666); err != nil {
+			return nil, err
+		}
+		job.Files = append(job.Files, name)
+	}
+	sort.Strings(job.Files)
+	select {
+	case s.queue <- job:
+	default:
+		return nil, fmt.Errorf("%d jobs already queued", maxQueuedJobs)
+	}
+	s.nextID++
+	s.jobs[job.ID] = job
+	s.saveJob(job)
+	return job, nil
+}
+
+//cancel drops a queued job or interrupts a running one, which then saves the results of the points it finished.
+func (s *jobServer) cancel(job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch job.Status {
+	case "queued":
+		now := time.Now()
+		job.Finished = &now
+	case "running":
+		if err := job.cmd.Process.Signal(os.Interrupt); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("job %d is already %s", job.ID, job.Status)
+	}
+	job.Status = "cancelled"
+	s.saveJob(job)
+	return nil
+}
+
+//writeJSON writes a value as the JSON response.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+//writeError writes an error as a JSON response.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+//handleJobs lists the jobs on GET and submits a job on POST. Jobs are submitted as a JSON object of CLI flags, such
+//as {"algo": "bch", "numsims": 30, "alphamax": 0.48}, with the files they read under "files", by name.
+func (s *jobServer) handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.Lock()
+		jobs := make([]Job, 0, len(s.jobs))
+		for _, job := range s.jobs {
+			jobs = append(jobs, *job)
+		}
+		s.mu.Unlock()
+		sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+		writeJSON(w, http.StatusOK, jobs)
+	case http.MethodPost:
+		var body map[string]interface{}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJobBytes)).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		params := make(map[string]string, len(body))
+		files := make(map[string]string)
+		for name, value := range body {
+			if name == "files" {
+				uploaded, ok := value.(map[string]interface{})
+				if !ok {
+					writeError(w, http.StatusBadRequest, fmt.Errorf("files must be an object of file names and contents"))
+					return
+				}
+				for fileName, content := range uploaded {
+					if files[fileName], ok = content.(string); !ok {
+						writeError(w, http.StatusBadRequest, fmt.Errorf("file %q must be a string", fileName))
+						return
+					}
+				}
+				continue
+			}
+			switch value.(type) {
+			case string, float64, bool:
+				params[name] = fmt.Sprint(value)
+			default:
+				writeError(w, http.StatusBadRequest, fmt.Errorf("parameter %q must be a string, number or boolean", name))
+				return
+			}
+		}
+		job, err := s.submit(params, files)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, s.snapshot(job))
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+//handleJob serves /jobs/id (GET), /jobs/id/cancel (POST), /jobs/id/results (GET) and /jobs/id/log (GET).
+func (s *jobServer) handleJob(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/"), "/")
+	id, err := strconv.Atoi(parts[0])
+	s.mu.Lock()
+	job, ok := s.jobs[id]
+	s.mu.Unlock()
+	if err != nil || !ok || len(parts) > 2 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no job at %s", r.URL.Path))
+		return
+	}
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+	method := http.MethodGet
+	if action == "cancel" {
+		method = http.MethodPost
+	}
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	switch action {
+	case "":
+		writeJSON(w, http.StatusOK, s.snapshot(job))
+	case "cancel":
+		if err := s.cancel(job); err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.snapshot(job))
+	case "results":
+		results := s.snapshot(job).Results
+		if results == "" {
+			writeError(w, http.StatusNotFound, fmt.Errorf("job %d has no results yet", id))
+			return
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("job%d_%s", id, results)))
+		http.ServeFile(w, r, filepath.Join(s.jobDir(id), results))
+	case "log":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		http.ServeFile(w, r, filepath.Join(s.jobDir(id), "output.log"))
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("no job at %s", r.URL.Path))
+	}
+}
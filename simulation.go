@@ -38,19 +38,60 @@ type Simulation struct {
 	stateHistory          []int
 	effectiveStateHistory []float64
 	simHistory            []float64
-	ID                    int //Simulation ID
+	ID                    int       //Simulation ID
+	feeSnipe              *FeeSnipe //Fee-sniping strategy parameters, nil for selfish mining
+	sniping               bool      //True while a fee-sniping fork is being mined
+	snipeRace             bool      //True while a fee-sniping fork is racing the main chain
+	numSnipes             int
+	successfulSnipes      int
+	isGHOST               bool     //Use the heaviest-subtree fork choice instead of the heaviest chain
+	network               *Network //Derives gamma from block propagation when set
+	simulatedGammas       []float64
+	propagationDelay      *Distribution //Seconds for an honest block to reach the other honest miners, nil for instant
+	honestFork            *Block        //Honest block competing with the tip after a natural fork
+	honestForkShare       float64       //Fraction of the honest hashrate mining on honestFork
+	naturalForks          int
+	naturalStales         int
+	honestSchedule        HashrateSchedule //Honest hashrate over time, relative to honestRatio
+	selfishSchedule       HashrateSchedule //Selfish hashrate over time, relative to alpha
+	selfishShareTime      float64          //Integral of the selfish share of the hashrate over real time
+	timestampAttack       bool             //Publish every block immediately, only applying the timestamp strategy
+	honestTimestamps      bool             //Stamp the attacker blocks of a timestamp attack honestly, as a baseline
+	attackEnd             int              //Real time the attacker stopped attacking, 0 while it has not
+	phases                *Phases          //Honest, attack and recovery phases, nil to attack throughout
 }
 
 //SimulationResult holds the results of a simulation
 type SimulationResult struct {
-	WinRatio               float64 `json:"winratio"`
-	AdjustedWinning        float64 `json:"adjustedwinning"`
-	SelfishSecondsPerBlock float64 `json:"selfishsecondsperblock"`
-	RelativeGain           float64 `json:"relativegain"`
-	AdjustedRelativeGain   float64 `json:"adjustedrelativegain"`
-	FinalHeight            int     `json:"finalheight"`
-	NumReorgs              int     `json:"numreorgs"`
-	SmWinReorgs            int     `json:"smwinreorgs"`
+	WinRatio               float64     `json:"winratio"`
+	AdjustedWinning        float64     `json:"adjustedwinning"`
+	SelfishSecondsPerBlock float64     `json:"selfishsecondsperblock"`
+	RelativeGain           float64     `json:"relativegain"`
+	AdjustedRelativeGain   float64     `json:"adjustedrelativegain"`
+	FinalHeight            int         `json:"finalheight"`
+	NumReorgs              int         `json:"numreorgs"`
+	SmWinReorgs            int         `json:"smwinreorgs"`
+	HonestOrphanRate       float64     `json:"honestorphanrate"`  //Fraction of honest blocks that were orphaned
+	SelfishOrphanRate      float64     `json:"selfishorphanrate"` //Fraction of selfish blocks that were orphaned
+	WastedHonestWork       float64     `json:"wastedhonestwork"`  //Fraction of honest work spent on orphaned blocks
+	ReorgDepths            map[int]int `json:"reorgdepths"`       //Number of reorgs by number of main chain blocks orphaned
+	SelfishRevenue         float64     `json:"selfishrevenue"`    //Subsidy and fees earned by the selfish miner, in coins
+	HonestRevenue          float64     `json:"honestrevenue"`     //Subsidy and fees earned by the honest miners, in coins
+	RevenueShare           float64     `json:"revenueshare"`      //Selfish fraction of the total revenue
+	RevenueRatio           float64     `json:"revenueratio"`      //Selfish revenue relative to honest revenue
+	RevenueRelativeGain    float64     `json:"revenuerelativegain"`
+	NumSnipes              int         `json:"numsnipes"`        //Fee-sniping forks started
+	SuccessfulSnipes       int         `json:"successfulsnipes"` //Fee-sniping forks that made it into the main chain
+	UncleRate              float64     `json:"unclerate"`        //Uncles referenced per main chain block
+	SimulatedGamma         float64     `json:"simulatedgamma"`   //Mean gamma over the races, when derived from the network
+	EffectiveAlpha         float64     `json:"effectivealpha"`   //Time averaged selfish share of the hashrate, alpha unless hashrate schedules are used
+	NaturalForks           int         `json:"naturalforks"`     //Honest-vs-honest forks caused by propagation delay
+	HonestStaleRate        float64     `json:"honeststalerate"`  //Fraction of honest blocks orphaned by natural forks
+	OutOfOrderRate         float64     `json:"outoforderrate"`   //Fraction of main chain blocks stamped before their parent
+	MeanDifficulty         float64     `json:"meandifficulty"`   //Mean difficulty of the simulated main chain blocks
+	EmissionRate           float64     `json:"emissionrate"`     //Main chain blocks per expected block time
+	ChainDistortion
+	Phases []PhaseResult `json:"phases,omitempty"` //Only with -phases
 }
 
 //Simulationer provides the methods a simulation must implement
@@ -77,9 +118,10 @@ var randsrc rand.Source
 //var START_TIME = 89400
 
 //init will initialize the simulation with the given parameters
-func (sim *Simulation) init(alpha float64, gamma float64, blocks, timewarp int, isBCHStrategic bool, diffAlgo Difficulty, expectedBlockTime int, id int) {
+func (sim *Simulation) init(alpha float64, gamma float64, blocks, timewarp int, isBCHStrategic bool, diffAlgo Difficulty, rewards RewardModel, expectedBlockTime int, id int) {
 	sim.expectedBlockTime = expectedBlockTime
 	sim.blockchain.expectedBlockTime = expectedBlockTime
+	sim.blockchain.rewards = rewards
 	sim.blockchain.Init()
 	sim.blockchain.setDiffAlgo(diffAlgo)
 	sim.numSimBlocks = blocks
@@ -93,7 +135,7 @@ func (sim *Simulation) init(alpha float64, gamma float64, blocks, timewarp int,
 	sim.realTime = sim.blockchain.time
 	rand.Seed(uint64(time.Now().UnixNano()))
 	randsrc = rand.NewSource(uint64(time.Now().UTC().UnixNano()))
-	sim.startTime = STARTING_BLOCKS * expectedBlockTime
+	sim.startTime = sim.blockchain.startingBlocks * expectedBlockTime
 	sim.ID = id
 }
 
@@ -106,9 +148,17 @@ func (sim *Simulation) reset() {
 	sim.effectiveStateHistory = nil
 }
 
+//postForkWork returns the work of each side of the fork according to the fork choice rule.
+func (sim *Simulation) postForkWork() (mainWork, privWork float64) {
+	if sim.isGHOST {
+		return sim.blockchain.getPostForkSubtreeWork()
+	}
+	return sim.blockchain.getPostForkWork()
+}
+
 func (sim *Simulation) setState(state int) {
 	//Add the if statement from python
-	mainWork, privWork := sim.blockchain.getPostForkWork()
+	mainWork, privWork := sim.postForkWork()
 	sim.effectiveState = privWork - mainWork
 	sim.effectiveStateHistory = append(sim.effectiveStateHistory, sim.effectiveState)
 	//positive ifLose means if we lose the next block, we are still ahead
@@ -142,42 +192,112 @@ func (sim *Simulation) setState(state int) {
 }
 
 func (sim *Simulation) setRealTime(timeOffset int) {
+	honest, selfish := sim.hashrates()
+	if honest+selfish > 0 {
+		sim.selfishShareTime += float64(timeOffset) * selfish / (honest + selfish)
+	}
 	sim.realTime += timeOffset
+	sim.blockchain.realTime = sim.realTime
 }
 
 //What is strategic in python code?
 func (sim *Simulation) newPrivateBlock() {
-	sim.blockchain.newPrivateBlock(sim.realTime + sim.timewarpOffset)
+	parent := sim.blockchain.privateParent()
+	sim.blockchain.newPrivateBlock(attackerTimestamps.stamp(sim.realTime, sim.timewarpOffset, parent, &sim.blockchain))
+}
+
+//hashrates returns the current honest and selfish hashrate, 1 being the total hashrate the chain starts with.
+func (sim *Simulation) hashrates() (honest, selfish float64) {
+	return sim.hashratesAt(sim.realTime)
+}
+
+//hashratesAt returns the honest and selfish hashrate at the given real time.
+func (sim *Simulation) hashratesAt(realTime int) (honest, selfish float64) {
+	elapsed := realTime - sim.startTime
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	honest = sim.honestRatio * sim.honestSchedule.at(elapsed)
+	selfish = sim.alpha * sim.selfishSchedule.at(elapsed)
+	return
+}
+
+//effectiveAlpha returns the selfish share of the hashrate averaged over the simulated time.
+func (sim *Simulation) effectiveAlpha() float64 {
+	elapsed := sim.realTime - sim.startTime
+	if (sim.honestSchedule.isConst() && sim.selfishSchedule.isConst()) || elapsed <= 0 {
+		return sim.alpha
+	}
+	return sim.selfishShareTime / float64(elapsed)
+}
+
+//neverDelay is the delay drawn for a miner without hashrate, which never finds a block
+const neverDelay = math.MaxInt32
+
+//drawDelay returns the seconds until a miner with the given share of the starting hashrate, changing over time as
+//the schedule says, finds a block at the given difficulty, +Inf if it never does.
+func (sim *Simulation) drawDelay(schedule HashrateSchedule, share, difficulty float64) float64 {
+	elapsed := math.Max(0, float64(sim.realTime-sim.startTime))
+	rate := share / (difficulty * float64(sim.expectedBlockTime))
+	return schedule.delay(elapsed, rate, distuv.Exponential{Rate: 1, Src: randsrc}.Rand())
+}
+
+//drawDelays draws the delays of both miners to their next block. Delays follow the hashrate schedules until the block
+//is found, and since block arrivals are memoryless, the delay of the miner that loses is simply drawn again.
+func (sim *Simulation) drawDelays() (delayHonest, delaySelfish float64) {
+	delayHonest = sim.drawDelay(sim.honestSchedule, sim.honestRatio, sim.blockchain.nextDifficulty)
+	delaySelfish = sim.drawDelay(sim.selfishSchedule, sim.alpha, sim.blockchain.nextPrivateDifficulty)
+	if math.IsInf(delayHonest, 1) && math.IsInf(delaySelfish, 1) {
+		log.WithField("RealTime", sim.realTime).Fatal("No hashrate left to mine the remaining blocks")
+	}
+	return
 }
 
 //lambda is the rate of block generation (poisson process)
 //getLambdas returns the lambdas for honest and selfish poisson
 func (sim *Simulation) getLambdas() (lambdaHonest, lambdaSelfish float64) {
-	lambdaHonest = 1.0 / ((sim.blockchain.nextDifficulty * float64(sim.expectedBlockTime)) / sim.honestRatio)
-	lambdaSelfish = 1.0 / ((sim.blockchain.nextPrivateDifficulty * float64(sim.expectedBlockTime)) / sim.alpha)
+	honest, selfish := sim.hashrates()
+	lambdaHonest = 1.0 / ((sim.blockchain.nextDifficulty * float64(sim.expectedBlockTime)) / honest)
+	lambdaSelfish = 1.0 / ((sim.blockchain.nextPrivateDifficulty * float64(sim.expectedBlockTime)) / selfish)
 	return
 }
 
-//getDelays returns the time for both honest and selfish miners to mine the next block
+//nextCommonBlock is used when both miners mine on the same tip: it advances the real time to the next
+//block and returns true if the selfish miner found it.
+func (sim *Simulation) nextCommonBlock() bool {
+	sim.blockchain.nextPrivateDifficulty = sim.blockchain.nextDifficulty
+	delayHonest, delaySelfish := sim.drawDelays()
+	if delaySelfish < delayHonest {
+		sim.setRealTime(int(delaySelfish))
+		return true
+	}
+	sim.setRealTime(int(delayHonest))
+	return false
+}
+
+//getDelays returns the time for both honest and selfish miners to mine the next block, neverDelay without hashrate
 func (sim *Simulation) getDelays() (delayHonest, delaySelfish int) {
-	lambdaHonest, lambdaSelfish := sim.getLambdas()
-	distHonest := distuv.Exponential{
-		Rate: lambdaHonest,
-		Src:  randsrc,
+	honest, selfish := sim.drawDelays()
+	return int(math.Min(honest, neverDelay)), int(math.Min(selfish, neverDelay))
+}
+
+//raceGamma returns the fraction of honest miners that mine on the selfish block during a race.
+//With a network it emerges from block propagation, and rational honest miners prefer a branch that leaves fees behind for them.
+func (sim *Simulation) raceGamma() float64 {
+	gamma := sim.gamma
+	if sim.network != nil {
+		gamma = sim.network.raceGamma()
+		sim.simulatedGammas = append(sim.simulatedGammas, gamma)
 	}
-	distSelfish := distuv.Exponential{
-		Rate: lambdaSelfish,
-		Src:  randsrc,
+	if sim.feeSnipe != nil && sim.feeSnipe.Undercut > 0 && sim.feeSnipe.UndercutGamma > gamma {
+		return sim.feeSnipe.UndercutGamma
 	}
-
-	delayHonest = int(distHonest.Rand())
-	delaySelfish = int(distSelfish.Rand())
-	return
+	return gamma
 }
 
 func (sim *Simulation) runSimulation(resultChannel chan<- SimulationResult) {
 	var res SimulationResult
-	for (sim.blockchain.height < STARTING_BLOCKS+sim.numSimBlocks) || (len(sim.blockchain.privateBranch) != 0) {
+	for (sim.blockchain.height < sim.blockchain.startingBlocks+sim.numSimBlocks) || (len(sim.blockchain.privateBranch) != 0) {
 		//No private branch, both mining at the same tip
 		privHeight := 0
 		if len(sim.blockchain.privateBranch) > 0 {
@@ -195,20 +315,28 @@ func (sim *Simulation) runSimulation(resultChannel chan<- SimulationResult) {
 			"RealTime":    sim.realTime,
 		}).Info("Simulating block")
 
+		if sim.phases != nil && sim.phaseStep() {
+			continue
+		}
+
+		if sim.timestampAttack {
+			sim.timestampStep()
+			continue
+		}
+
+		if sim.feeSnipe != nil && (sim.state == 0 || sim.sniping) {
+			sim.feeSnipeStep()
+			continue
+		}
+
 		if sim.state == 0 {
-			sim.blockchain.nextPrivateDifficulty = sim.blockchain.nextDifficulty
-			lambd := 1.0 / (sim.blockchain.nextDifficulty * float64(sim.expectedBlockTime))
-			//delay := generator.Poisson(lambd)
-			delay := distuv.Exponential{
-				Rate: lambd,
-				Src:  randsrc,
-			}.Rand()
-			sim.setRealTime(int(delay))
-
-			if rand.Float64() <= sim.alpha { //Selfish wins
+			if sim.nextCommonBlock() { //Selfish wins
+				sim.resolveHonestFork(false)
 				sim.zeroToOne()
 			} else {
+				sim.resolveHonestFork(true)
 				sim.blockchain.newBlock(sim.realTime)
+				sim.maybeHonestFork()
 			}
 			continue
 		}
@@ -240,20 +368,30 @@ func (sim *Simulation) runSimulation(resultChannel chan<- SimulationResult) {
 		if sim.state == -1 {
 			res.NumReorgs++
 			delayHonest, delaySelfish := sim.getDelays()
+			smBlockWins := true
 			if delaySelfish < delayHonest {
 				sim.smWinsRace(delaySelfish)
 				res.SmWinReorgs++
 			} else {
-				if rand.Float64() < sim.gamma { //HM mines on SM block
+				if rand.Float64() < sim.raceGamma() { //HM mines on SM block
 					sim.hmOnSm(delayHonest)
 				} else {
 					sim.hmWinsRace(delayHonest)
+					smBlockWins = false
 				}
 			}
+			if sim.snipeRace && smBlockWins {
+				sim.successfulSnipes++
+			}
+			sim.snipeRace = false
 			continue
 		}
 	}
 
+	if sim.honestFork != nil {
+		sim.resolveHonestFork(false)
+	}
+
 	for i := 0; i <= sim.blockchain.height; i++ {
 		block := sim.blockchain.chain[i]
 		if i != block.height {
@@ -261,13 +399,55 @@ func (sim *Simulation) runSimulation(resultChannel chan<- SimulationResult) {
 		}
 	}
 
+	alpha := sim.effectiveAlpha()
+	res.EffectiveAlpha = alpha
+
+	ts := sim.blockchain.treeStats()
+	if ts.honestMined > 0 {
+		res.HonestOrphanRate = float64(ts.honestOrphaned) / float64(ts.honestMined)
+		res.WastedHonestWork = ts.wastedHonestWork / ts.honestWork
+	}
+	if ts.selfishMined > 0 {
+		res.SelfishOrphanRate = float64(ts.selfishOrphaned) / float64(ts.selfishMined)
+	}
+	res.ReorgDepths = sim.blockchain.reorgDepthHistogram()
+	res.NaturalForks = sim.naturalForks
+	res.OutOfOrderRate = sim.blockchain.outOfOrderRate()
+	if ts.honestMined > 0 {
+		res.HonestStaleRate = float64(sim.naturalStales) / float64(ts.honestMined)
+	}
+
+	res.NumSnipes = sim.numSnipes
+	res.SuccessfulSnipes = sim.successfulSnipes
+
+	rs := sim.blockchain.rewardStats()
+	res.SelfishRevenue = rs.selfishRevenue
+	res.HonestRevenue = rs.honestRevenue
+	if totalRevenue := rs.selfishRevenue + rs.honestRevenue; totalRevenue > 0 {
+		res.RevenueShare = rs.selfishRevenue / totalRevenue
+		res.RevenueRelativeGain = (res.RevenueShare - alpha) / alpha
+	}
+	if rs.honestRevenue > 0 {
+		res.RevenueRatio = rs.selfishRevenue / rs.honestRevenue
+	}
+	res.UncleRate = float64(rs.uncles) / float64(sim.blockchain.height-sim.blockchain.startingBlocks)
+	res.ChainDistortion = sim.chainDistortion(rs.emitted)
+	if sim.phases != nil {
+		res.Phases = sim.phaseResults(res.ChainDistortion)
+	}
+	if len(sim.simulatedGammas) > 0 {
+		res.SimulatedGamma = sum(sim.simulatedGammas...) / float64(len(sim.simulatedGammas))
+	}
+
 	sm, _, winRatio := sim.blockchain.stats()
 	elapsedTime := sim.realTime - sim.startTime
-	timeRatio := float64(elapsedTime) / float64((sim.blockchain.height-STARTING_BLOCKS)*sim.expectedBlockTime)
+	timeRatio := float64(elapsedTime) / float64((sim.blockchain.height-sim.blockchain.startingBlocks)*sim.expectedBlockTime)
 	res.WinRatio = winRatio
 	res.AdjustedWinning = winRatio / timeRatio
-	res.RelativeGain = (winRatio - sim.alpha) / sim.alpha
-	res.AdjustedRelativeGain = (res.AdjustedWinning - sim.alpha) / sim.alpha
+	res.EmissionRate = 1 / timeRatio
+	res.MeanDifficulty = sumBlocks(sim.blockchain.chain[sim.blockchain.startingBlocks:]...) / float64(sim.blockchain.height+1-sim.blockchain.startingBlocks)
+	res.RelativeGain = (winRatio - alpha) / alpha
+	res.AdjustedRelativeGain = (res.AdjustedWinning - alpha) / alpha
 
 	if sm == 0 {
 		res.SelfishSecondsPerBlock = -1
@@ -356,9 +536,7 @@ func (sim *Simulation) hmWinsRace(delay int) {
 	log.Debug("hwWinsRace")
 	sim.setRealTime(delay)
 	sim.blockchain.newBlock(sim.realTime)
-	for len(sim.blockchain.privateBranch) > 0 {
-		sim.blockchain.popFromPrivateChain()
-	}
+	sim.blockchain.clearPrivateBrach()
 	sim.blockchain.nextPrivateDifficulty = sim.blockchain.nextDifficulty
 	sim.blockchain.setForkHeight(0)
 	sim.setState(0)
@@ -0,0 +1,275 @@
+//go:build ignore
+
+//genheaders writes the synthetic header fixtures of validate_test.go. Run it from the repository root with
+//	go run testdata/genheaders.go
+//Timestamps are random, with exponential solvetimes drifting away from the target so that the algos have something
+//to adjust to, and every difficulty is computed with the consensus rule of the chain, in integer targets and compact
+//bits as the reference clients do. Heights and timestamps are made up, they do not match any real block.
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"math/big"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+)
+
+var rng = rand.New(rand.NewSource(20261018))
+
+//setCompact decodes compact bits into a target.
+func setCompact(c uint32) *big.Int {
+	size := c >> 24
+	word := big.NewInt(int64(c & 0x007fffff))
+	if size <= 3 {
+		return word.Rsh(word, uint(8*(3-size)))
+	}
+	return word.Lsh(word, uint(8*(size-3)))
+}
+
+//getCompact encodes a target as compact bits.
+func getCompact(t *big.Int) uint32 {
+	size := uint((t.BitLen() + 7) / 8)
+	var compact uint64
+	if size <= 3 {
+		compact = t.Uint64() << (8 * (3 - size))
+	} else {
+		compact = new(big.Int).Rsh(t, 8*(size-3)).Uint64()
+	}
+	if compact&0x00800000 != 0 {
+		compact >>= 8
+		size++
+	}
+	return uint32(compact) | uint32(size)<<24
+}
+
+//times returns n unique timestamps after start with exponential solvetimes, the mean solvetime being multiplied by
+//drift every block, plus up to 30 seconds of clock jitter.
+func times(n int, start int64, mean, drift float64) []int64 {
+	t := float64(start)
+	seen := make(map[int64]bool)
+	var out []int64
+	for i := 0; i < n; i++ {
+		t += rng.ExpFloat64() * mean * math.Pow(drift, float64(i))
+		ts := int64(t) + rng.Int63n(61) - 30
+		for seen[ts] {
+			ts = int64(t) + rng.Int63n(61) - 30
+		}
+		seen[ts] = true
+		out = append(out, ts)
+	}
+	return out
+}
+
+//jitter returns the bits of a target within 5% of the given one.
+func jitter(bits uint32) uint32 {
+	t := setCompact(bits)
+	t.Mul(t, big.NewInt(95+rng.Int63n(11)))
+	return getCompact(t.Div(t, big.NewInt(100)))
+}
+
+//write writes the fixture of a chain, the last column being bits in hex or difficulties.
+func write(name string, h0 int, ts []int64, last string, values []string) {
+	f, err := os.Create("testdata/" + name + "_headers.csv")
+	if err != nil {
+		panic(err)
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"height", "timestamp", last})
+	for i := range ts {
+		w.Write([]string{strconv.Itoa(h0 + i), strconv.FormatInt(ts[i], 10), values[i]})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		panic(err)
+	}
+	f.Close()
+}
+
+func hexBits(bits []uint32) []string {
+	var out []string
+	for _, b := range bits {
+		out = append(out, fmt.Sprintf("%08x", b))
+	}
+	return out
+}
+
+//btc is one retarget period starting at a retarget height, then blocks at the next target: the span from the first to
+//the last block of the period, clamped to a quarter and four times two weeks, scales the target.
+func btc() {
+	const period, target = 2016, 2016 * 600
+	n := period + 24
+	ts := times(n, 1000000000, 560, 1)
+	bits := make([]uint32, n)
+	for i := 0; i < period; i++ {
+		bits[i] = 0x17053894
+	}
+	span := ts[period-1] - ts[0]
+	if span < target/4 {
+		span = target / 4
+	} else if span > target*4 {
+		span = target * 4
+	}
+	next := setCompact(bits[0])
+	next.Mul(next, big.NewInt(span)).Div(next, big.NewInt(target))
+	for i := period; i < n; i++ {
+		bits[i] = getCompact(next)
+	}
+	write("btc", 2*period, ts, "bits", hexBits(bits))
+}
+
+//bch is the cw-144 algo of Bitcoin Cash from November 2017: the work after the suitable block (median of 3) 144 blocks
+//back up to the suitable block of the tip, over the time between them clamped to 72-288 block times.
+func bch() {
+	const window = 147
+	n := window + 53
+	ts := times(n, 1000000000, 600, 0.995)
+	bits := make([]uint32, window)
+	for i := range bits {
+		bits[i] = 0x1802e7d5
+	}
+	suitable := func(i int) int {
+		b := []int{i - 2, i - 1, i}
+		sort.Slice(b, func(x, y int) bool { return ts[b[x]] < ts[b[y]] })
+		return b[1]
+	}
+	two256 := new(big.Int).Lsh(big.NewInt(1), 256)
+	work := func(b uint32) *big.Int {
+		t := setCompact(b)
+		return t.Div(two256, t.Add(t, big.NewInt(1)))
+	}
+	for k := window; k < n; k++ {
+		last, first := suitable(k-1), suitable(k-1-144)
+		w := new(big.Int)
+		for i := first + 1; i <= last; i++ {
+			w.Add(w, work(bits[i]))
+		}
+		span := ts[last] - ts[first]
+		if span < 72*600 {
+			span = 72 * 600
+		} else if span > 288*600 {
+			span = 288 * 600
+		}
+		w.Mul(w, big.NewInt(600)).Div(w, big.NewInt(span))
+		next := new(big.Int).Sub(two256, w)
+		bits = append(bits, getCompact(next.Div(next, w)))
+	}
+	write("bch", 1000, ts, "bits", hexBits(bits))
+}
+
+//dash is DarkGravityWave v3: the weighted average of the last 24 targets scaled by the span of those blocks,
+//clamped to 1/3-3 times 24 block times.
+func dash() {
+	const window, target = 24, 24 * 150
+	n := window + 56
+	ts := times(n, 1000000000, 150, 0.99)
+	bits := make([]uint32, window)
+	for i := range bits {
+		bits[i] = jitter(0x1924b1c4)
+	}
+	for k := window; k < n; k++ {
+		avg := new(big.Int)
+		p := k - 1
+		for c := int64(1); c <= window; c++ {
+			t := setCompact(bits[p])
+			if c == 1 {
+				avg = t
+			} else {
+				avg.Mul(avg, big.NewInt(c)).Add(avg, t).Div(avg, big.NewInt(c+1))
+			}
+			if c != window {
+				p--
+			}
+		}
+		span := ts[k-1] - ts[p]
+		if span > target*3 {
+			span = target * 3
+		} else if span < target/3 {
+			span = target / 3
+		}
+		avg.Mul(avg, big.NewInt(span)).Div(avg, big.NewInt(target))
+		bits = append(bits, getCompact(avg))
+	}
+	write("dash", 1000, ts, "bits", hexBits(bits))
+}
+
+//zec is Digishield v3 as run by Zcash: the mean of the last 17 targets scaled by the span between the medians of 11
+//timestamps ending at the tip and 17 blocks earlier, dampened by 4 and clamped to 84-132% of 17 block times.
+func zec() {
+	const window, interval, target = 28, 17, 17 * 150
+	n := window + 52
+	ts := times(n, 1000000000, 150, 1.005)
+	bits := make([]uint32, window)
+	for i := range bits {
+		bits[i] = jitter(0x1c0287ef)
+	}
+	mtp := func(i int) int64 {
+		past := append([]int64(nil), ts[i-10:i+1]...)
+		sort.Slice(past, func(x, y int) bool { return past[x] < past[y] })
+		return past[5]
+	}
+	for k := window; k < n; k++ {
+		last := k - 1
+		avg := new(big.Int)
+		for i := last - interval + 1; i <= last; i++ {
+			avg.Add(avg, setCompact(bits[i]))
+		}
+		avg.Div(avg, big.NewInt(interval))
+		//Go division truncates toward zero like C++
+		span := mtp(last) - mtp(last-interval)
+		span = target + (span-target)/4
+		if span < target*84/100 {
+			span = target * 84 / 100
+		} else if span > target*132/100 {
+			span = target * 132 / 100
+		}
+		avg.Div(avg, big.NewInt(target)).Mul(avg, big.NewInt(span))
+		bits = append(bits, getCompact(avg))
+	}
+	write("zec", 1000, ts, "bits", hexBits(bits))
+}
+
+//xmr is the Monero next_difficulty: 720 blocks lagged by 15, the 60 earliest and latest timestamps once sorted cut
+//from the span and the matching difficulties from the work, rounded up.
+func xmr() {
+	const window = 735
+	n := window + 65
+	ts := times(n, 1000000000, 120, 1.0003)
+	diffs := make([]int64, window)
+	for i := range diffs {
+		diffs[i] = 300000000000 + rng.Int63n(18000000001) - 9000000000
+	}
+	for k := window; k < n; k++ {
+		var sorted []int64
+		var cum []int64
+		c := int64(0)
+		for i := k - 735; i < k-15; i++ {
+			sorted = append(sorted, ts[i])
+			c += diffs[i]
+			cum = append(cum, c)
+		}
+		sort.Slice(sorted, func(x, y int) bool { return sorted[x] < sorted[y] })
+		span := sorted[659] - sorted[60]
+		if span <= 0 {
+			span = 1
+		}
+		total := cum[659] - cum[60]
+		diffs = append(diffs, (total*120+span-1)/span)
+	}
+	values := make([]string, n)
+	for i, d := range diffs {
+		values[i] = strconv.FormatInt(d, 10)
+	}
+	write("xmr", 1000, ts, "difficulty", values)
+}
+
+func main() {
+	btc()
+	bch()
+	dash()
+	zec()
+	xmr()
+}
@@ -0,0 +1,53 @@
+package main
+
+//TimestampAttackResults compares a timestamp attack, where the attacker publishes every block immediately but stamps
+//it with its timestamp strategy, with the same simulations where it stamps its blocks honestly.
+type TimestampAttackResults struct {
+	MeanDifficulty       float64 `json:"meandifficulty"`
+	BaselineDifficulty   float64 `json:"baselinedifficulty"`
+	DifficultyReduction  float64 `json:"difficultyreduction"` //1 - attack / baseline mean difficulty
+	EmissionRate         float64 `json:"emissionrate"`        //Main chain blocks per expected block time
+	BaselineEmissionRate float64 `json:"baselineemissionrate"`
+	EmissionSpeedup      float64 `json:"emissionspeedup"` //attack / baseline emission rate - 1
+	AttackerShare        float64 `json:"attackershare"`   //Fraction of the main chain blocks mined by the attacker
+	BaselineShare        float64 `json:"baselineshare"`
+	ShareGain            float64 `json:"sharegain"` //attack / baseline share - 1
+}
+
+//timestampStep simulates the next block of a timestamp attack: both miners mine on the tip and publish immediately.
+func (sim *Simulation) timestampStep() {
+	sim.publicStep(!sim.honestTimestamps)
+}
+
+//runTimestampBaseline runs numSims simulations from newSim with the attacker stamping honestly, and compares them with
+//the attack results.
+func runTimestampBaseline(attack []SimulationResult, newSim func() *Simulation, numSims int) *TimestampAttackResults {
+	resultChannel := make(chan SimulationResult, numSims)
+	for i := 0; i < numSims; i++ {
+		sim := newSim()
+		sim.honestTimestamps = true
+		go sim.runSimulation(resultChannel)
+	}
+	baseline := make([]SimulationResult, numSims)
+	for i := range baseline {
+		baseline[i] = <-resultChannel
+	}
+
+	var r TimestampAttackResults
+	for i := range attack {
+		r.MeanDifficulty += attack[i].MeanDifficulty / float64(len(attack))
+		r.EmissionRate += attack[i].EmissionRate / float64(len(attack))
+		r.AttackerShare += attack[i].WinRatio / float64(len(attack))
+	}
+	for i := range baseline {
+		r.BaselineDifficulty += baseline[i].MeanDifficulty / float64(numSims)
+		r.BaselineEmissionRate += baseline[i].EmissionRate / float64(numSims)
+		r.BaselineShare += baseline[i].WinRatio / float64(numSims)
+	}
+	r.DifficultyReduction = 1 - r.MeanDifficulty/r.BaselineDifficulty
+	r.EmissionSpeedup = r.EmissionRate/r.BaselineEmissionRate - 1
+	if r.BaselineShare > 0 {
+		r.ShareGain = r.AttackerShare/r.BaselineShare - 1
+	}
+	return &r
+}
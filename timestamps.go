@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//TimestampStrategy is how the attacker stamps its blocks, set by -timestamps. With T the timewarp of the attacker:
+//	offset		the real time plus T
+//	alternate	alternately the real time plus T and minus T, to stretch the timestamp spread seen by trimming algos
+//	mtp		the earliest timestamp allowed, one second after the median time past of the parent
+//	reverse		T seconds (1 if T is 0) before the parent, for timestamps going backwards
+//	file:name	the real time plus an offset read from a file of one offset in seconds per line, cycled through
+//			by height from the first simulated block
+//Except for offset, timestamps are never at or before the median time past of the parent, as consensus rules require.
+type TimestampStrategy struct {
+	Spec    string `json:"spec"`
+	kind    string
+	offsets []int
+}
+
+//attackerTimestamps is the timestamp strategy of the attacker in every simulation of this run
+var attackerTimestamps = TimestampStrategy{Spec: "offset", kind: "offset"}
+
+//parseTimestampStrategy parses a timestamp strategy spec.
+func parseTimestampStrategy(spec string) (TimestampStrategy, error) {
+	s := TimestampStrategy{Spec: spec}
+	parts := strings.SplitN(strings.TrimSpace(spec), ":", 2)
+	s.kind = strings.ToLower(parts[0])
+	switch s.kind {
+	case "", "offset":
+		s.kind = "offset"
+	case "alternate", "mtp", "reverse":
+	case "file":
+		if len(parts) != 2 {
+			return s, fmt.Errorf("missing file in %q", spec)
+		}
+		if err := s.loadOffsets(parts[1]); err != nil {
+			return s, err
+		}
+		if len(s.offsets) == 0 {
+			return s, fmt.Errorf("no offsets in %q", spec)
+		}
+	default:
+		return s, fmt.Errorf("invalid timestamp strategy %q", spec)
+	}
+	return s, nil
+}
+
+//loadOffsets reads one timestamp offset per line, skipping empty lines and # comments.
+func (s *TimestampStrategy) loadOffsets(fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		offset, err := strconv.Atoi(text)
+		if err != nil {
+			return fmt.Errorf("line %d of %s: invalid offset %q", line, fileName, text)
+		}
+		s.offsets = append(s.offsets, offset)
+	}
+	return scanner.Err()
+}
+
+//stamp returns the timestamp of an attacker block found at the given real time on top of parent.
+func (s TimestampStrategy) stamp(time, timewarp int, parent Block, blockchain *Blockchain) int {
+	var timestamp int
+	height := parent.height + 1 - blockchain.startingBlocks
+	switch s.kind {
+	case "", "offset":
+		return time + timewarp
+	case "alternate":
+		if height%2 == 0 {
+			timestamp = time + timewarp
+		} else {
+			timestamp = time - timewarp
+		}
+	case "mtp":
+		timestamp = blockchain.medianTimePast(parent) + 1
+	case "reverse":
+		step := timewarp
+		if step == 0 {
+			step = 1
+		}
+		timestamp = parent.timestamp - step
+	case "file":
+		i := height % len(s.offsets)
+		if i < 0 {
+			i += len(s.offsets)
+		}
+		timestamp = time + s.offsets[i]
+	}
+	if mtp := blockchain.medianTimePast(parent); timestamp <= mtp {
+		timestamp = mtp + 1
+	}
+	return timestamp
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	color "github.com/logrusorgru/aurora"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/rand"
+	distuv "gonum.org/v1/gonum/stat/distuv"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//ChainConfig describes one of the chains of a two-chain simulation
+type ChainConfig struct {
+	Algo      string      `yaml:"algo" json:"algo"`
+	Hashrate  float64     `yaml:"hashrate" json:"hashrate"`   //Fraction of the total hashrate that only ever mines this chain
+	Price     float64     `yaml:"price" json:"price"`         //Value of one coin, only the ratio between the chains matters
+	Blocktime int         `yaml:"blocktime" json:"blocktime"` //Default for the algo if 0
+	Params    Difficulty  `yaml:"-" json:"difficulty_parameters"`
+	Rewards   RewardModel `yaml:"-" json:"reward_parameters"`
+}
+
+//TwoChainConfig describes two chains sharing a PoW algorithm, as read from the -twochain YAML file.
+//Besides the loyal hashrate of each chain, a pool of rational miners mines whichever chain is currently
+//more profitable, and a selfish miner may attack one of the chains. Shares are fractions of the total
+//hashrate of both chains and must add up to 1.
+type TwoChainConfig struct {
+	Chains       []ChainConfig `yaml:"chains" json:"chains"`
+	Switching    float64       `yaml:"switching" json:"switching"`       //Hashrate of the profit-switching pool
+	Threshold    float64       `yaml:"threshold" json:"threshold"`       //Relative profitability advantage needed before the pool switches
+	Selfish      float64       `yaml:"selfish" json:"selfish"`           //Hashrate of the selfish miner, 0 for none
+	SelfishChain int           `yaml:"selfishchain" json:"selfishchain"` //Index of the chain the selfish miner attacks
+	Strategy     string        `yaml:"strategy" json:"strategy"`         //selfish or stubborn
+	Timewarp     int           `yaml:"timewarp" json:"timewarp"`         //Seconds added to the timestamps of the selfish blocks
+}
+
+//Indices of the miners of each chain of a two-chain simulation
+const (
+	loyalMiner = iota
+	switchingMiner
+	selfishMiner
+)
+
+//TwoChainSimulation simulates two chains mined side by side, each with its own blockchain and difficulty.
+//Blocks of both chains are found by a single poisson process, so the chains share the same real time.
+//Every chain is a MultiSimulation whose miner shares are in units of the hashrate its starting difficulty stands for.
+type TwoChainSimulation struct {
+	config      *TwoChainConfig
+	chains      [2]*MultiSimulation
+	scale       [2]float64 //Fraction of the total hashrate that a difficulty of 1 stands for on each chain
+	poolChain   int        //Chain the switching pool mines
+	elapsed     float64    //Seconds since the first simulated block
+	numSwitches int
+	minerTime   [2][]float64 //Integral of the hashrate of each miner of each chain over real time
+	hashSqTime  [2]float64   //Integral of the square of each chain's hashrate over real time
+}
+
+//ChainResult holds the results of one chain of a two-chain simulation
+type ChainResult struct {
+	Blocks              int     `json:"blocks"`              //Simulated main chain blocks
+	TimeRatio           float64 `json:"timeratio"`           //Mean time between blocks relative to the expected block time
+	DifficultyAmplitude float64 `json:"difficultyamplitude"` //Std dev of the difficulty of the main chain blocks relative to its mean
+	HashrateAmplitude   float64 `json:"hashrateamplitude"`   //Time weighted std dev of the chain's hashrate relative to its mean
+	PoolShare           float64 `json:"poolshare"`           //Fraction of the time the switching pool mined this chain
+	LoyalProfitRate     float64 `json:"loyalprofitrate"`     //Value earned per day by the loyal miners per unit of total hashrate
+	NumReorgs           float64 `json:"numreorgs"`
+}
+
+//TwoChainResult holds the results of a two-chain simulation
+type TwoChainResult struct {
+	Chains              []ChainResult `json:"chains"`
+	NumSwitches         int           `json:"numswitches"`
+	PoolProfitRate      float64       `json:"poolprofitrate"`      //Value earned per day by the switching pool per unit of total hashrate
+	SelfishRevenue      float64       `json:"selfishrevenue"`      //Coins earned by the selfish miner
+	SelfishProfitRate   float64       `json:"selfishprofitrate"`   //Value earned per day by the selfish miner per unit of total hashrate
+	SelfishRelativeGain float64       `json:"selfishrelativegain"` //Selfish profit rate relative to the loyal miners of its chain
+}
+
+//TwoChainAvgResults contains the average results for numsims two-chain simulations with the given params
+type TwoChainAvgResults struct {
+	NumSims             int           `json:"numsims"`
+	Gamma               float64       `json:"gamma"`
+	Numblocks           int           `json:"numblocks"`
+	Chains              []ChainResult `json:"chains"`
+	NumSwitches         float64       `json:"numswitches"`
+	PoolProfitRate      float64       `json:"poolprofitrate"`
+	SelfishRevenue      float64       `json:"selfishrevenue"`
+	SelfishProfitRate   float64       `json:"selfishprofitrate"`
+	SelfishRelativeGain float64       `json:"selfishrelativegain"`
+	SelfishGainStdDev   float64       `json:"selfishgainstddev"`
+}
+
+//loadTwoChainConfig reads the chains of a two-chain simulation from a YAML file, along with the difficulty
+//and reward parameters of their algos.
+func loadTwoChainConfig(fileName string) (*TwoChainConfig, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config := TwoChainConfig{Strategy: "selfish"}
+	if err := yaml.NewDecoder(bufio.NewReader(f)).Decode(&config); err != nil {
+		return nil, err
+	}
+	if len(config.Chains) != 2 {
+		return nil, fmt.Errorf("two-chain simulation needs 2 chains, got %d", len(config.Chains))
+	}
+	total := config.Switching + config.Selfish
+	for i := range config.Chains {
+		chain := &config.Chains[i]
+		chain.Algo = strings.ToLower(chain.Algo)
+		if _, ok := lookupDifficulty(chain.Algo); !ok {
+			return nil, fmt.Errorf("chain %d has invalid algo %s", i, chain.Algo)
+		}
+		if chain.Hashrate <= 0.0 || chain.Price <= 0.0 || chain.Blocktime < 0 {
+			return nil, fmt.Errorf("chain %d needs a positive hashrate and price", i)
+		}
+		if chain.Blocktime == 0 {
+			chain.Blocktime = defaultBlockTime(chain.Algo)
+		}
+		chain.Params = loadYamlFile(chain.Algo)
+		if err := warmUp.checkHistory(chain.Algo, chain.Params); err != nil {
+			return nil, fmt.Errorf("chain %d: %v", i, err)
+		}
+		chain.Rewards = loadRewardModel(chain.Algo)
+		total += chain.Hashrate
+	}
+	config.Strategy = strings.ToLower(config.Strategy)
+	if config.Strategy != "selfish" && config.Strategy != "stubborn" {
+		return nil, fmt.Errorf("invalid selfish strategy %s", config.Strategy)
+	}
+	if config.Switching < 0.0 || config.Threshold < 0.0 || config.Selfish < 0.0 || config.SelfishChain < 0 || config.SelfishChain > 1 {
+		return nil, fmt.Errorf("invalid switching or selfish parameters")
+	}
+	if math.Abs(total-1.0) > 1e-6 {
+		return nil, fmt.Errorf("shares add up to %f instead of 1", total)
+	}
+	return &config, nil
+}
+
+//init will initialize the simulation with the given parameters. The switching pool starts on the first chain.
+func (sim *TwoChainSimulation) init(config *TwoChainConfig, gamma float64, blocks int) {
+	sim.config = config
+	sim.poolChain = 0
+	for c, chain := range config.Chains {
+		shares := [3]float64{chain.Hashrate}
+		if c == sim.poolChain {
+			shares[switchingMiner] = config.Switching
+		}
+		if c == config.SelfishChain {
+			shares[selfishMiner] = config.Selfish
+		}
+		sim.scale[c] = sum(shares[:]...)
+
+		//Miners keep their index on both chains, a miner that is absent from a chain has no share
+		miners := []MinerConfig{
+			{Name: "loyal", Share: shares[loyalMiner] / sim.scale[c], Strategy: "honest"},
+			{Name: "switching", Share: shares[switchingMiner] / sim.scale[c], Strategy: "honest"},
+			{Name: "selfish", Share: shares[selfishMiner] / sim.scale[c], Strategy: config.Strategy, Timewarp: config.Timewarp},
+		}
+		sim.chains[c] = &MultiSimulation{}
+		sim.chains[c].init(miners, gamma, blocks, chain.Params, chain.Rewards, chain.Blocktime)
+		sim.minerTime[c] = make([]float64, len(miners))
+	}
+}
+
+//hashrate returns the hashrate of a miner of the given chain as a fraction of the total hashrate.
+func (sim *TwoChainSimulation) hashrate(c, miner int) float64 {
+	return sim.chains[c].miners[miner].Share * sim.scale[c]
+}
+
+//profitability returns the value one unit of hashrate is expected to earn per second on the given chain
+//at its current difficulty.
+func (sim *TwoChainSimulation) profitability(c int) float64 {
+	chain := sim.chains[c]
+	r := chain.blockchain.rewards
+	height := r.StartHeight + chain.blockchain.height + 1 - chain.blockchain.startingBlocks
+	reward := r.subsidy(height, r.StartEmitted) + r.expectedFees(chain.expectedBlockTime)
+	work := chain.difficulty(chain.mainTip().id) * sim.scale[c] * float64(chain.expectedBlockTime)
+	return sim.config.Chains[c].Price * reward / work
+}
+
+//maybeSwitch moves the switching pool to the other chain if it has become more profitable.
+func (sim *TwoChainSimulation) maybeSwitch() {
+	from, to := sim.poolChain, 1-sim.poolChain
+	if sim.config.Switching == 0.0 || sim.profitability(to) <= sim.profitability(from)*(1+sim.config.Threshold) {
+		return
+	}
+	log.WithFields(log.Fields{
+		"From":     sim.config.Chains[from].Algo,
+		"To":       sim.config.Chains[to].Algo,
+		"Elapsed":  sim.elapsed,
+		"Switches": sim.numSwitches,
+	}).Info("Switching pool moves")
+
+	sim.chains[from].miners[switchingMiner].Share = 0
+	pool := sim.chains[to].miners[switchingMiner]
+	pool.Share = sim.config.Switching / sim.scale[to]
+	pool.tip = sim.chains[to].mainTip().id
+	pool.racing = false
+	sim.poolChain = to
+	sim.numSwitches++
+}
+
+//setRealTime advances the real time of both chains and integrates the hashrate of every miner over it.
+func (sim *TwoChainSimulation) setRealTime(timeOffset int) {
+	for c, chain := range sim.chains {
+		chainHashrate := 0.0
+		for i := range chain.miners {
+			sim.minerTime[c][i] += sim.hashrate(c, i) * float64(timeOffset)
+			chainHashrate += sim.hashrate(c, i)
+		}
+		sim.hashSqTime[c] += chainHashrate * chainHashrate * float64(timeOffset)
+		chain.realTime += timeOffset
+		chain.blockchain.realTime = chain.realTime
+	}
+	sim.elapsed += float64(timeOffset)
+}
+
+//step advances the simulation to the next block, found by one of the miners of either chain.
+func (sim *TwoChainSimulation) step() {
+	var rates [2][]float64
+	totalRate := 0.0
+	for c, chain := range sim.chains {
+		var chainRate float64
+		rates[c], chainRate = chain.rates()
+		totalRate += chainRate
+	}
+	delay := distuv.Exponential{
+		Rate: totalRate,
+		Src:  randsrc,
+	}.Rand()
+	sim.setRealTime(int(delay))
+
+	pick := rand.Float64() * totalRate
+	for c := range rates {
+		for i, rate := range rates[c] {
+			if pick < rate {
+				sim.chains[c].mine(i)
+				sim.maybeSwitch()
+				return
+			}
+			pick -= rate
+		}
+	}
+	//Rounding errors, the last miner with any hashrate wins
+	for c := len(rates) - 1; c >= 0; c-- {
+		for i := len(rates[c]) - 1; i >= 0; i-- {
+			if rates[c][i] > 0 {
+				sim.chains[c].mine(i)
+				sim.maybeSwitch()
+				return
+			}
+		}
+	}
+}
+
+//runSimulation runs until either chain has mined numSimBlocks blocks.
+func (sim *TwoChainSimulation) runSimulation(resultChannel chan<- TwoChainResult) {
+	done := func() bool {
+		for _, chain := range sim.chains {
+			if chain.blockchain.height >= chain.blockchain.startingBlocks+chain.numSimBlocks {
+				return true
+			}
+		}
+		return false
+	}
+	for !done() {
+		sim.step()
+	}
+
+	res := TwoChainResult{Chains: make([]ChainResult, len(sim.chains)), NumSwitches: sim.numSwitches}
+	var loyalProfitRates [2]float64
+	poolProfit := 0.0
+	for c, chain := range sim.chains {
+		chain.orphanStale()
+		config := sim.config.Chains[c]
+		r := &res.Chains[c]
+		r.Blocks = chain.blockchain.height - chain.blockchain.startingBlocks
+		r.NumReorgs = float64(len(chain.blockchain.reorgDepths))
+		if sim.elapsed <= 0 || r.Blocks == 0 {
+			continue
+		}
+		r.TimeRatio = sim.elapsed / float64(r.Blocks*chain.expectedBlockTime)
+
+		var diffs []float64
+		for _, block := range chain.blockchain.chain[chain.blockchain.startingBlocks:] {
+			diffs = append(diffs, block.difficulty)
+		}
+		r.DifficultyAmplitude = calcStdDev(diffs) / (sum(diffs...) / float64(len(diffs)))
+
+		meanHashrate := sum(sim.minerTime[c]...) / sim.elapsed
+		if variance := sim.hashSqTime[c]/sim.elapsed - meanHashrate*meanHashrate; variance > 0 && meanHashrate > 0 {
+			r.HashrateAmplitude = math.Sqrt(variance) / meanHashrate
+		}
+		if sim.config.Switching > 0 {
+			r.PoolShare = sim.minerTime[c][switchingMiner] / (sim.config.Switching * sim.elapsed)
+		}
+
+		revenue := make([]float64, len(chain.miners))
+		chain.blockchain.walkRewards(func(block Block, reward float64) {
+			revenue[block.miner] += reward
+		})
+		profitRate := func(miner int) float64 {
+			if sim.minerTime[c][miner] == 0 {
+				return 0
+			}
+			return config.Price * revenue[miner] / sim.minerTime[c][miner] * secondsPerDay
+		}
+		r.LoyalProfitRate = profitRate(loyalMiner)
+		loyalProfitRates[c] = r.LoyalProfitRate
+		poolProfit += config.Price * revenue[switchingMiner]
+		if c == sim.config.SelfishChain {
+			res.SelfishRevenue = revenue[selfishMiner]
+			res.SelfishProfitRate = profitRate(selfishMiner)
+		}
+	}
+
+	if sim.config.Switching > 0 && sim.elapsed > 0 {
+		res.PoolProfitRate = poolProfit / (sim.config.Switching * sim.elapsed) * secondsPerDay
+	}
+	if sim.config.Selfish > 0 && loyalProfitRates[sim.config.SelfishChain] > 0 {
+		res.SelfishRelativeGain = (res.SelfishProfitRate - loyalProfitRates[sim.config.SelfishChain]) / loyalProfitRates[sim.config.SelfishChain]
+	}
+	resultChannel <- res
+}
+
+//runTwoChainSweep runs numSims two-chain simulations for every gamma in the range and appends the averages to the results.
+func runTwoChainSweep(config *TwoChainConfig, numSims, numBlocks int, gamma, gammaMax, gammaStep float64) {
+	resultChannel := make(chan TwoChainResult, numSims)
+	for gammaT := gamma; gammaT <= gammaMax; gammaT = toFixed(gammaT+gammaStep, 3) {
+		fmt.Printf("Simulating: Chains: %s/%s\tGamma: %f", color.Green(config.Chains[0].Algo), color.Green(config.Chains[1].Algo), color.Cyan(gammaT))
+		simTime := time.Now()
+		for i := 0; i < numSims; i++ {
+			var sim TwoChainSimulation
+			sim.init(config, gammaT, numBlocks)
+			go sim.runSimulation(resultChannel)
+		}
+
+		avgResults := TwoChainAvgResults{
+			NumSims:   numSims,
+			Gamma:     gammaT,
+			Numblocks: numBlocks,
+			Chains:    make([]ChainResult, len(config.Chains)),
+		}
+		gainHistory := make([]float64, numSims)
+		n := float64(numSims)
+		for i := 0; i < numSims; i++ {
+			res := <-resultChannel
+			for c, chainRes := range res.Chains {
+				a := &avgResults.Chains[c]
+				a.Blocks += chainRes.Blocks
+				a.TimeRatio += chainRes.TimeRatio / n
+				a.DifficultyAmplitude += chainRes.DifficultyAmplitude / n
+				a.HashrateAmplitude += chainRes.HashrateAmplitude / n
+				a.PoolShare += chainRes.PoolShare / n
+				a.LoyalProfitRate += chainRes.LoyalProfitRate / n
+				a.NumReorgs += chainRes.NumReorgs / n
+			}
+			avgResults.NumSwitches += float64(res.NumSwitches) / n
+			avgResults.PoolProfitRate += res.PoolProfitRate / n
+			avgResults.SelfishRevenue += res.SelfishRevenue / n
+			avgResults.SelfishProfitRate += res.SelfishProfitRate / n
+			avgResults.SelfishRelativeGain += res.SelfishRelativeGain / n
+			gainHistory[i] = res.SelfishRelativeGain
+		}
+		for c := range avgResults.Chains {
+			avgResults.Chains[c].Blocks /= numSims
+		}
+		avgResults.SelfishGainStdDev = calcStdDev(gainHistory)
+
+		results.TwoChainResults = append(results.TwoChainResults, avgResults)
+		fmt.Printf("\t(%s)\n", time.Since(simTime))
+		for c, chainRes := range avgResults.Chains {
+			fmt.Printf("\t%s: pool share %f, difficulty amplitude %f, hashrate amplitude %f\n", config.Chains[c].Algo,
+				chainRes.PoolShare, chainRes.DifficultyAmplitude, chainRes.HashrateAmplitude)
+		}
+		fmt.Printf("\tSwitches: %f\tSelfish relative gain: %f\n", avgResults.NumSwitches, avgResults.SelfishRelativeGain)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//HeaderRecord is one row of historical header data
+type HeaderRecord struct {
+	Height     int
+	Timestamp  int
+	Difficulty float64
+}
+
+//BlockValidation compares the difficulty of a historical block with the one the algo computes from the blocks before it
+type BlockValidation struct {
+	Height        int     `json:"height"`
+	Recorded      float64 `json:"recorded"`
+	Predicted     float64 `json:"predicted"`
+	RelativeError float64 `json:"relativeerror"` //(predicted - recorded) / recorded
+}
+
+//compactToDifficulty converts nBits, the compact target of Bitcoin-like headers, to a difficulty relative to 0x1d00ffff.
+func compactToDifficulty(bits uint32) float64 {
+	exponent := int(bits >> 24)
+	mantissa := float64(bits & 0x007fffff)
+	target := math.Ldexp(mantissa, 8*(exponent-3))
+	maxTarget := math.Ldexp(0xffff, 8*(0x1d-3))
+	return maxTarget / target
+}
+
+//loadHeaders reads header data from a CSV file with a header row naming a height, a timestamp (or time) and
+//a difficulty (or bits/nbits, in hex) column. Rows must be consecutive blocks in increasing height.
+func loadHeaders(fileName string) ([]HeaderRecord, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) < 2 {
+		return nil, fmt.Errorf("%s has no header data", fileName)
+	}
+
+	heightCol, timeCol, diffCol, bitsCol := -1, -1, -1, -1
+	for i, name := range rows[0] {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "height":
+			heightCol = i
+		case "timestamp", "time":
+			timeCol = i
+		case "difficulty":
+			diffCol = i
+		case "bits", "nbits":
+			bitsCol = i
+		}
+	}
+	if heightCol < 0 || timeCol < 0 || (diffCol < 0 && bitsCol < 0) {
+		return nil, fmt.Errorf("%s needs height, timestamp and difficulty or bits columns", fileName)
+	}
+
+	var headers []HeaderRecord
+	for i, row := range rows[1:] {
+		var h HeaderRecord
+		var err error
+		if len(row) < len(rows[0]) {
+			return nil, fmt.Errorf("row %d of %s has missing columns", i+2, fileName)
+		}
+		if h.Height, err = strconv.Atoi(strings.TrimSpace(row[heightCol])); err != nil {
+			return nil, fmt.Errorf("row %d of %s: invalid height", i+2, fileName)
+		}
+		if h.Timestamp, err = strconv.Atoi(strings.TrimSpace(row[timeCol])); err != nil {
+			return nil, fmt.Errorf("row %d of %s: invalid timestamp", i+2, fileName)
+		}
+		if diffCol >= 0 {
+			h.Difficulty, err = strconv.ParseFloat(strings.TrimSpace(row[diffCol]), 64)
+		} else {
+			var bits uint64
+			bits, err = strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(row[bitsCol]), "0x"), 16, 32)
+			h.Difficulty = compactToDifficulty(uint32(bits))
+		}
+		if err != nil || h.Difficulty <= 0 {
+			return nil, fmt.Errorf("row %d of %s: invalid difficulty", i+2, fileName)
+		}
+		if len(headers) > 0 && h.Height != headers[len(headers)-1].Height+1 {
+			return nil, fmt.Errorf("row %d of %s: height %d does not follow %d", i+2, fileName, h.Height, headers[len(headers)-1].Height)
+		}
+		headers = append(headers, h)
+	}
+	return headers, nil
+}
+
+//validateDifficulty feeds the headers to the algo one block at a time and compares every difficulty it computes with
+//the recorded difficulty of the next block. The chain is padded below the first header with blocks at its difficulty
+//and at the expected block time so that every algo has a full window, and so that heights modulo the retarget period
+//match the real ones for algos that only retarget every period.
+func validateDifficulty(diffAlgo Difficulty, headers []HeaderRecord, expectedBlockTime int) []BlockValidation {
+	first := headers[0]
+	padding := warmUp.Blocks + first.Height%retargetPeriod(diffAlgo)
+
+	chain := make([]Block, 0, padding+len(headers))
+	for i := 0; i < padding; i++ {
+		chain = append(chain, Block{height: i, id: i, parent: i - 1, isHonest: true, difficulty: first.Difficulty,
+			timestamp: first.Timestamp - (padding-i)*expectedBlockTime})
+	}
+	for _, h := range headers {
+		i := len(chain)
+		chain = append(chain, Block{height: i, id: i, parent: i - 1, isHonest: true, difficulty: h.Difficulty,
+			timestamp: h.Timestamp})
+	}
+
+	var validations []BlockValidation
+	for i := padding; i < len(chain)-1; i++ {
+		blockchain := Blockchain{chain: chain[:i+1], tree: chain[:i+1], height: i, expectedBlockTime: expectedBlockTime,
+			startingBlocks: warmUp.Blocks}
+		predicted := diffAlgo.getDiff(false, blockchain)
+		recorded := chain[i+1].difficulty
+		validations = append(validations, BlockValidation{
+			Height:        headers[i+1-padding].Height,
+			Recorded:      recorded,
+			Predicted:     predicted,
+			RelativeError: (predicted - recorded) / recorded,
+		})
+	}
+	return validations
+}
+
+//retargetPeriod returns the number of blocks between two adjustments of the algo, counted from the first simulated
+//block, 1 for the algos that adjust every block.
+func retargetPeriod(diffAlgo Difficulty) int {
+	switch d := diffAlgo.(type) {
+	case btcDifficulty:
+		return d.Period
+	case edaDifficulty:
+		return d.Period
+	case formulaDifficulty:
+		if d.Retarget > 1 {
+			return d.Retarget
+		}
+	}
+	return 1
+}
+
+//runValidation validates the algo against the header data in fileName and prints the error statistics of the blocks
+//after the first skip ones. Per-block results are written to outFileName as CSV if it is set.
+func runValidation(daa string, diffAlgo Difficulty, fileName string, expectedBlockTime, skip int, outFileName string) error {
+	headers, err := loadHeaders(fileName)
+	if err != nil {
+		return err
+	}
+	validations := validateDifficulty(diffAlgo, headers, expectedBlockTime)
+
+	if outFileName != "" {
+		f, err := os.Create(outFileName)
+		if err != nil {
+			return err
+		}
+		w := csv.NewWriter(f)
+		w.Write([]string{"height", "recorded", "predicted", "relativeerror"})
+		for _, v := range validations {
+			w.Write([]string{strconv.Itoa(v.Height), strconv.FormatFloat(v.Recorded, 'g', -1, 64),
+				strconv.FormatFloat(v.Predicted, 'g', -1, 64), strconv.FormatFloat(v.RelativeError, 'g', -1, 64)})
+		}
+		w.Flush()
+		f.Close()
+		if err := w.Error(); err != nil {
+			return err
+		}
+	}
+
+	if skip >= len(validations) {
+		return fmt.Errorf("skipping %d of %d validated blocks leaves none", skip, len(validations))
+	}
+	var absErrors []float64
+	maxError, maxHeight, exact := 0.0, 0, 0
+	for _, v := range validations[skip:] {
+		absError := math.Abs(v.RelativeError)
+		absErrors = append(absErrors, absError)
+		if absError > maxError {
+			maxError, maxHeight = absError, v.Height
+		}
+		if absError < 1e-6 {
+			exact++
+		}
+	}
+	n := float64(len(absErrors))
+	squares := 0.0
+	for _, e := range absErrors {
+		squares += e * e
+	}
+
+	fmt.Printf("Validated %s against %s: %d blocks (%d skipped)\n", daa, fileName, len(absErrors), skip)
+	fmt.Printf("Mean absolute relative error:\t%e\n", sum(absErrors...)/n)
+	fmt.Printf("RMS relative error:\t\t%e\n", math.Sqrt(squares/n))
+	fmt.Printf("Max absolute relative error:\t%e (height %d)\n", maxError, maxHeight)
+	fmt.Printf("Within 1e-6:\t\t\t%f\n", float64(exact)/n)
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestCompactToDifficulty(t *testing.T) {
+	tests := []struct {
+		bits uint32
+		want float64
+	}{
+		{0x1d00ffff, 1},
+		{0x1b0404cb, 16307.420938523983},
+		{0x207fffff, 4.656542373906925e-10},
+	}
+	for _, test := range tests {
+		if got := compactToDifficulty(test.bits); math.Abs(got-test.want) > 1e-12*test.want {
+			t.Errorf("compactToDifficulty(%#x) = %v, want %v", test.bits, got, test.want)
+		}
+	}
+}
+
+//The fixtures in testdata are synthetic headers written by testdata/genheaders.go (go run testdata/genheaders.go to
+//regenerate them) with the consensus rule of every chain, so the errors left are the
+//simplifications of the simulated algos: btc sums the work of 2015 blocks instead of 2016, bch includes the first
+//block of its window, xmr sums 600 difficulties instead of 599 over a window one block earlier, and all of them
+//work with difficulties instead of rounded compact targets.
+func TestValidateDifficulty(t *testing.T) {
+	tests := []struct {
+		algo    string
+		skip    int //Blocks predicted from the padding before the first header
+		maxMean float64
+		maxErr  float64
+	}{
+		{"btc", 0, 1e-6, 1e-3},
+		{"bch", 146, 1e-2, 1e-2},
+		{"dash", 23, 1e-5, 1e-5},
+		{"zec", 27, 1e-3, 1e-3},
+		{"xmr", 734, 1e-2, 1e-2},
+	}
+	log.SetLevel(log.WarnLevel)
+	for _, test := range tests {
+		def, ok := lookupDifficulty(test.algo)
+		if !ok {
+			t.Fatalf("%s is not registered", test.algo)
+		}
+		headers, err := loadHeaders("testdata/" + test.algo + "_headers.csv")
+		if err != nil {
+			t.Fatalf("%s: %v", test.algo, err)
+		}
+		validations := validateDifficulty(def.Params, headers, def.BlockTime)
+		if len(validations) != len(headers)-1 {
+			t.Fatalf("%s: %d validations for %d headers", test.algo, len(validations), len(headers))
+		}
+
+		sumErr, maxErr := 0.0, 0.0
+		for _, v := range validations[test.skip:] {
+			sumErr += math.Abs(v.RelativeError)
+			maxErr = math.Max(maxErr, math.Abs(v.RelativeError))
+		}
+		meanErr := sumErr / float64(len(validations)-test.skip)
+		t.Logf("%s: mean %e, max %e", test.algo, meanErr, maxErr)
+		if meanErr > test.maxMean || maxErr > test.maxErr {
+			t.Errorf("%s: mean error %e and max error %e, want at most %e and %e", test.algo, meanErr, maxErr, test.maxMean, test.maxErr)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	distuv "gonum.org/v1/gonum/stat/distuv"
+)
+
+//WarmUp describes how the starting blocks before the simulated ones are generated. Specs are written as
+//	constant		difficulty 1 and exactly the expected block time between blocks
+//	poisson			difficulty 1 and exponentially distributed solvetimes
+//	history:file		imported from a CSV (see loadHeaders) or JSON file of real headers
+//Imported difficulties are divided by the mean difficulty of the last normalizeBlocks blocks, so the simulated
+//hashrate of 1 is what the chain had at the end of the history.
+type WarmUp struct {
+	Spec         string `json:"spec"`
+	Blocks       int    `json:"blocks"`
+	method       string
+	timestamps   []int //Imported history, oldest first, last timestamp at 0
+	difficulties []float64
+}
+
+const normalizeBlocks = 144
+
+//warmUp is the warm-up of every simulation of this run
+var warmUp = WarmUp{Spec: "constant", Blocks: defaultStartingBlocks, method: "constant"}
+
+//parseWarmUp parses a warm-up spec. blocks is the number of starting blocks, 0 for the default:
+//defaultStartingBlocks generated blocks or the whole history.
+func parseWarmUp(spec string, blocks int) (WarmUp, error) {
+	w := WarmUp{Spec: spec, Blocks: blocks}
+	parts := strings.SplitN(strings.TrimSpace(spec), ":", 2)
+	w.method = strings.ToLower(parts[0])
+	switch w.method {
+	case "", "constant", "poisson":
+		if w.method == "" {
+			w.method = "constant"
+		}
+		if w.Blocks == 0 {
+			w.Blocks = defaultStartingBlocks
+		}
+	case "history":
+		if len(parts) != 2 {
+			return w, fmt.Errorf("missing file in %q", spec)
+		}
+		headers, err := loadHistory(parts[1])
+		if err != nil {
+			return w, err
+		}
+		if w.Blocks == 0 {
+			w.Blocks = len(headers)
+		}
+		if w.Blocks > len(headers) {
+			return w, fmt.Errorf("%d starting blocks requested but %s only has %d", w.Blocks, parts[1], len(headers))
+		}
+		headers = headers[len(headers)-w.Blocks:]
+
+		norm := 0.0
+		last := headers
+		if len(headers) > normalizeBlocks {
+			last = headers[len(headers)-normalizeBlocks:]
+		}
+		for _, h := range last {
+			norm += h.Difficulty
+		}
+		norm /= float64(len(last))
+		for _, h := range headers {
+			w.timestamps = append(w.timestamps, h.Timestamp-headers[len(headers)-1].Timestamp)
+			w.difficulties = append(w.difficulties, h.Difficulty/norm)
+		}
+	default:
+		return w, fmt.Errorf("invalid warm-up %q", spec)
+	}
+	if w.Blocks < 2 {
+		return w, fmt.Errorf("invalid number of starting blocks %d", w.Blocks)
+	}
+	return w, nil
+}
+
+//checkHistory returns an error if the warm-up is shorter than the history the algo reads to compute the difficulty
+//of the first simulated block.
+func (w WarmUp) checkHistory(algo string, params Difficulty) error {
+	def, ok := lookupDifficulty(algo)
+	if !ok {
+		return fmt.Errorf("invalid diff algo %s", algo)
+	}
+	if minBlocks := def.MinHistory(params); w.Blocks < minBlocks {
+		return fmt.Errorf("%s needs at least %d starting blocks, got %d", algo, minBlocks, w.Blocks)
+	}
+	return nil
+}
+
+//loadHistory reads headers from a JSON file, an array of objects with a timestamp and either a difficulty or
+//bits (compact target in hex), or from a CSV file otherwise.
+func loadHistory(fileName string) ([]HeaderRecord, error) {
+	if !strings.HasSuffix(strings.ToLower(fileName), ".json") {
+		return loadHeaders(fileName)
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rows []struct {
+		Height     int     `json:"height"`
+		Timestamp  int     `json:"timestamp"`
+		Difficulty float64 `json:"difficulty"`
+		Bits       string  `json:"bits"`
+	}
+	if err := json.NewDecoder(bufio.NewReader(f)).Decode(&rows); err != nil {
+		return nil, err
+	}
+	var headers []HeaderRecord
+	for i, row := range rows {
+		h := HeaderRecord{Height: row.Height, Timestamp: row.Timestamp, Difficulty: row.Difficulty}
+		if row.Bits != "" {
+			bits, err := strconv.ParseUint(strings.TrimPrefix(row.Bits, "0x"), 16, 32)
+			if err != nil {
+				return nil, fmt.Errorf("header %d of %s: invalid bits", i, fileName)
+			}
+			h.Difficulty = compactToDifficulty(uint32(bits))
+		}
+		if h.Difficulty <= 0 {
+			return nil, fmt.Errorf("header %d of %s: invalid difficulty", i, fileName)
+		}
+		headers = append(headers, h)
+	}
+	return headers, nil
+}
+
+//blocks generates the starting blocks of a chain with the given expected block time. The last one is
+//stamped (Blocks-1) block times after genesis, as with perfect spacing.
+func (w WarmUp) blocks(expectedBlockTime int) []Block {
+	blocks := make([]Block, w.Blocks)
+	t := 0.0
+	for i := range blocks {
+		block := Block{height: i, difficulty: BASElINE_DIFFICULTY, isHonest: true, parent: i - 1}
+		switch w.method {
+		case "constant":
+			block.timestamp = i * expectedBlockTime
+		case "poisson":
+			if i > 0 {
+				t += distuv.Exponential{Rate: 1.0 / float64(expectedBlockTime), Src: randsrc}.Rand()
+			}
+			block.timestamp = int(math.Round(t))
+		case "history":
+			block.timestamp = w.timestamps[i]
+			block.difficulty = w.difficulties[i]
+		}
+		blocks[i] = block
+	}
+	shift := (w.Blocks-1)*expectedBlockTime - blocks[w.Blocks-1].timestamp
+	for i := range blocks {
+		blocks[i].timestamp += shift
+		blocks[i].minedAt = blocks[i].timestamp
+	}
+	return blocks
+}